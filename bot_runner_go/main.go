@@ -1,16 +1,22 @@
 package main
 
 import (
+	"os"
+	"slices"
+
 	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/autoconfig"
 	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/runner"
 	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/zerologger"
-	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/runner/helloworld"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/runner/admin"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/runner/natsserver"
 	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/runner/wxauto"
 )
 
 type Config struct {
 	Logger           zerologger.Config  `yaml:"logger"`             // 日志配置
-	HelloWorldRunner *helloworld.Config `yaml:"hello_world_runner"` // HelloWorldRunner配置
+	Runner           runner.Config      `yaml:"runner"`             // runner 监督配置
+	NatsServerRunner *natsserver.Config `yaml:"nats_server_runner"` // 内嵌 NATS 服务器配置，不配置时连接外部 NATS 服务器
+	AdminRunner      *admin.Config      `yaml:"admin_runner"`       // 管理服务配置，提供健康检查与状态页
 	WxAutoRunner     *wxauto.Config     `yaml:"wxauto_runner"`      // 微信机器人runner配置
 }
 
@@ -20,9 +26,20 @@ func main() {
 	logger := zerologger.MustNewLogger(&cfg.Logger)
 	defer logger.Close()
 
-	runner.Run(
-		*logger.Logger,
-		helloworld.New(cfg.HelloWorldRunner),
-		wxauto.MustNew(cfg.WxAutoRunner),
-	)
+	// runner 按依赖顺序排列，停止时按相反顺序逐个停止
+	var runners []runner.Runner
+	if cfg.NatsServerRunner != nil {
+		// 内嵌服务器需先于其他 runner 创建，以便注册进程内连接，并在其他 runner 停止后才停止
+		runners = append(runners, natsserver.MustNew(cfg.NatsServerRunner))
+	}
+	wxAutoRunner := wxauto.MustNew(cfg.WxAutoRunner)
+	if cfg.AdminRunner != nil {
+		// 管理服务在业务 runner 之后停止，停止期间就绪探针仍可反映各 runner 的状态
+		runners = append(runners, admin.MustNew(cfg.AdminRunner, append(slices.Clone(runners), wxAutoRunner)...))
+	}
+	runners = append(runners, wxAutoRunner)
+	if err := runner.Run(*logger.Logger, &cfg.Runner, runners...); err != nil {
+		logger.Close()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,41 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version 构建版本，发布时通过 -ldflags "-X github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/buildinfo.Version=v1.2.3" 注入
+var Version = "dev"
+
+// Info 构建信息
+type Info struct {
+	Version   string `json:"version"`              // 构建版本
+	Revision  string `json:"revision,omitempty"`   // 构建时的 git 提交
+	Modified  bool   `json:"modified,omitempty"`   // 构建时工作区是否有未提交的修改
+	BuildTime string `json:"build_time,omitempty"` // 构建时 git 提交的时间
+	GoVersion string `json:"go_version"`           // Go 版本
+}
+
+// Get 返回构建信息，未注入版本时使用 go install 记录的模块版本
+func Get() Info {
+	info := Info{Version: Version, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		case "vcs.time":
+			info.BuildTime = s.Value
+		}
+	}
+	return info
+}
@@ -0,0 +1,37 @@
+package chathistory
+
+import (
+	"errors"
+	"time"
+)
+
+type Config struct {
+	MaxMessages int           `yaml:"max_messages"`  // 每个会话保留的最大历史消息条数
+	MaxTokens   int           `yaml:"max_tokens"`    // 每次提问携带的历史消息最大估算 token 数
+	TTL         time.Duration `yaml:"ttl"`           // 历史消息过期时间
+	KeyBySender bool          `yaml:"key_by_sender"` // 是否按照会话+发送者区分历史，默认仅按会话区分
+	Store       StoreConfig   `yaml:"store"`         // 历史存储配置
+}
+
+func (c *Config) Validate() error {
+	if c.MaxMessages == 0 {
+		c.MaxMessages = 20
+	}
+	if c.MaxTokens == 0 {
+		c.MaxTokens = 2000
+	}
+	if c.TTL == 0 {
+		c.TTL = 30 * time.Minute
+	}
+
+	if c.MaxMessages <= 0 {
+		return errors.New("max_messages must be greater than 0")
+	}
+	if c.MaxTokens <= 0 {
+		return errors.New("max_tokens must be greater than 0")
+	}
+	if c.TTL <= 0 {
+		return errors.New("ttl must be greater than 0")
+	}
+	return c.Store.Validate()
+}
@@ -0,0 +1,92 @@
+package chathistory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ Store = (*FileStore)(nil)
+
+var fileStoreBucket = []byte("chat_history")
+
+// FileStore 基于 BoltDB 的本地文件存储，每个会话的消息列表以 JSON 保存在同一个 bucket 中
+type FileStore struct {
+	db *bolt.DB
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	// 确保数据文件夹已创建
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory %s: %w", dir, err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(fileStoreBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create history bucket: %w", err)
+	}
+	return &FileStore{db: db}, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (msgs []Message, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(fileStoreBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &msgs)
+	})
+	return
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, msgs []Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putMessages(tx.Bucket(fileStoreBucket), key, msgs)
+	})
+}
+
+func (s *FileStore) Update(ctx context.Context, key string, fn func(msgs []Message) ([]Message, error)) error {
+	// BoltDB 的写事务互斥，读改写在同一个事务中完成
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fileStoreBucket)
+		var msgs []Message
+		if data := bucket.Get([]byte(key)); data != nil {
+			if err := json.Unmarshal(data, &msgs); err != nil {
+				return err
+			}
+		}
+		msgs, err := fn(msgs)
+		if err != nil {
+			return err
+		}
+		return putMessages(bucket, key, msgs)
+	})
+}
+
+func putMessages(bucket *bolt.Bucket, key string, msgs []Message) error {
+	if len(msgs) == 0 {
+		return bucket.Delete([]byte(key))
+	}
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
+
+func (s *FileStore) Close() error {
+	return s.db.Close()
+}
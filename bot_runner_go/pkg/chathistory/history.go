@@ -0,0 +1,138 @@
+package chathistory
+
+import (
+	"context"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 会话中的一条历史消息
+type Message struct {
+	Role      schema.RoleType `json:"role"`              // 消息角色，user 或 assistant
+	Content   string          `json:"content"`           // 消息内容
+	CreatedAt time.Time       `json:"created_at"`        // 消息记录时间
+	TurnID    string          `json:"turn_id,omitempty"` // 所属问答轮次的 ID，同一轮次只记录一次
+}
+
+// 根据配置生成会话历史的键，默认按会话名称区分，开启 key_by_sender 后按会话+发送者区分
+func (c *Config) SessionKey(chatName, sender string) string {
+	if c != nil && c.KeyBySender {
+		return chatName + "/" + sender
+	}
+	return chatName
+}
+
+type History struct {
+	cfg   *Config
+	store Store
+	now   func() time.Time
+}
+
+func New(ctx context.Context, cfg *Config) (*History, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	store, err := newStore(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &History{
+		cfg:   cfg,
+		store: store,
+		now:   time.Now,
+	}, nil
+}
+
+// Load 返回会话中未过期的历史消息，按时间先后排列，超出 token 预算时优先丢弃较早的消息
+func (h *History) Load(ctx context.Context, key string) ([]*schema.Message, error) {
+	msgs, err := h.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	msgs = h.unexpired(msgs)
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	// 从最新的消息开始倒序累加，直到超出 token 预算
+	start, tokens := len(msgs), 0
+	for i := len(msgs) - 1; i >= 0; i-- {
+		tokens += EstimateTokens(msgs[i].Content)
+		if tokens > h.cfg.MaxTokens {
+			break
+		}
+		start = i
+	}
+	// 历史的第一条消息必须是用户消息，避免出现没有提问的回答
+	for start < len(msgs) && msgs[start].Role != schema.User {
+		start++
+	}
+
+	ret := make([]*schema.Message, 0, len(msgs)-start)
+	for _, msg := range msgs[start:] {
+		ret = append(ret, &schema.Message{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+	return ret, nil
+}
+
+// Append 向会话追加历史消息，并按照窗口大小丢弃最早的消息
+func (h *History) Append(ctx context.Context, key string, msgs ...*schema.Message) error {
+	return h.AppendTurn(ctx, key, "", msgs...)
+}
+
+// AppendTurn 追加一轮问答的消息，turnID 为空时与 Append 相同；
+// 会话中已有该轮次的消息时忽略，重新处理同一条消息不会重复记录
+func (h *History) AppendTurn(ctx context.Context, key, turnID string, msgs ...*schema.Message) error {
+	now := h.now()
+	return h.store.Update(ctx, key, func(history []Message) ([]Message, error) {
+		history = h.unexpired(history)
+		if turnID != "" && slices.ContainsFunc(history, func(msg Message) bool { return msg.TurnID == turnID }) {
+			return history, nil
+		}
+		for _, msg := range msgs {
+			history = append(history, Message{
+				Role:      msg.Role,
+				Content:   msg.Content,
+				CreatedAt: now,
+				TurnID:    turnID,
+			})
+		}
+		if len(history) > h.cfg.MaxMessages {
+			history = history[len(history)-h.cfg.MaxMessages:]
+		}
+		return history, nil
+	})
+}
+
+func (h *History) Close() error {
+	return h.store.Close()
+}
+
+// unexpired 丢弃已过期的消息
+func (h *History) unexpired(msgs []Message) []Message {
+	deadline := h.now().Add(-h.cfg.TTL)
+	i := 0
+	for i < len(msgs) && msgs[i].CreatedAt.Before(deadline) {
+		i++
+	}
+	return msgs[i:]
+}
+
+// EstimateTokens 粗略估算文本的 token 数：ASCII 字符按 4 个字符一个 token，其余字符按一个字符一个 token
+func EstimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
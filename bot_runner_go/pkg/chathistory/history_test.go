@@ -0,0 +1,206 @@
+package chathistory
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/testharness"
+)
+
+func TestHistoryWindowAndExpiry(t *testing.T) {
+	h, err := New(context.Background(), &Config{
+		MaxMessages: 4,
+		MaxTokens:   1000,
+		TTL:         time.Minute,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	now := time.Now()
+	h.now = func() time.Time { return now }
+
+	// 写入三轮问答，窗口只保留最近的四条
+	for i := range 3 {
+		err := h.Append(ctx, "group", schema.UserMessage(string(rune('a'+i))), schema.AssistantMessage(string(rune('A'+i)), nil))
+		require.NoError(t, err)
+	}
+	msgs, err := h.Load(ctx, "group")
+	require.NoError(t, err)
+	require.Len(t, msgs, 4)
+	require.Equal(t, "b", msgs[0].Content)
+	require.Equal(t, schema.User, msgs[0].Role)
+	require.Equal(t, "C", msgs[3].Content)
+
+	// 其他会话互不影响
+	msgs, err = h.Load(ctx, "other")
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+
+	// 超过过期时间后历史被清空
+	now = now.Add(2 * time.Minute)
+	msgs, err = h.Load(ctx, "group")
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+}
+
+func TestHistoryTokenBudget(t *testing.T) {
+	h, err := New(context.Background(), &Config{
+		MaxMessages: 10,
+		MaxTokens:   5,
+		TTL:         time.Minute,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, h.Append(ctx, "group", schema.UserMessage("你好"), schema.AssistantMessage("你好呀", nil)))
+	require.NoError(t, h.Append(ctx, "group", schema.UserMessage("在吗"), schema.AssistantMessage("在", nil)))
+
+	// 预算只够最近一轮问答
+	msgs, err := h.Load(ctx, "group")
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.Equal(t, "在吗", msgs[0].Content)
+	require.Equal(t, "在", msgs[1].Content)
+}
+
+func TestHistoryAppendTurn(t *testing.T) {
+	h, err := New(context.Background(), &Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// 同一轮次重复追加时只记录一次
+	for range 2 {
+		require.NoError(t, h.AppendTurn(ctx, "group", "msg-1", schema.UserMessage("你好"), schema.AssistantMessage("你好呀", nil)))
+	}
+	require.NoError(t, h.AppendTurn(ctx, "group", "msg-2", schema.UserMessage("你好"), schema.AssistantMessage("又见面了", nil)))
+	msgs, err := h.Load(ctx, "group")
+	require.NoError(t, err)
+	require.Len(t, msgs, 4)
+	require.Equal(t, "又见面了", msgs[3].Content)
+}
+
+func TestSessionKey(t *testing.T) {
+	var cfg *Config
+	require.Equal(t, "group", cfg.SessionKey("group", "alice"))
+	cfg = &Config{KeyBySender: true}
+	require.Equal(t, "group/alice", cfg.SessionKey("group", "alice"))
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(2)
+	require.NoError(t, s.Put(ctx, "a", []Message{{Content: "a"}}))
+	require.NoError(t, s.Put(ctx, "b", []Message{{Content: "b"}}))
+
+	// 访问 a 后写入 c，最久未访问的 b 被淘汰
+	_, err := s.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "c", []Message{{Content: "c"}}))
+
+	msgs, err := s.Get(ctx, "b")
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+	msgs, err = s.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+}
+
+func TestFileStorePersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "测试群", []Message{{Role: schema.User, Content: "你好"}}))
+	require.NoError(t, s.Close())
+
+	// 重新打开后历史仍然存在
+	s, err = NewFileStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	msgs, err := s.Get(ctx, "测试群")
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "你好", msgs[0].Content)
+}
+
+func TestSharedStores(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+	stores := NewStores()
+
+	// 相同的文件路径只打开一次，两个 History 读写同一份历史
+	a, err := stores.New(ctx, &Config{Store: StoreConfig{Type: StoreTypeFile, Path: path}})
+	require.NoError(t, err)
+	b, err := stores.New(ctx, &Config{MaxMessages: 10, Store: StoreConfig{Type: StoreTypeFile, Path: path}})
+	require.NoError(t, err)
+	require.NoError(t, a.Append(ctx, "group", schema.UserMessage("你好")))
+	msgs, err := b.Load(ctx, "group")
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+
+	// 最后一个引用关闭后才关闭存储，之后可以重新打开
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Append(ctx, "group", schema.UserMessage("在吗")))
+	require.NoError(t, b.Close())
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	stored, err := s.Get(ctx, "group")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+}
+
+func TestNatsKVStore(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	var logs bytes.Buffer
+	ctx := zerolog.New(&logs).WithContext(context.Background())
+
+	s, err := NewNatsKVStore(ctx, srv.URL(), "CHAT_HISTORY", time.Hour)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Put(ctx, "测试群", []Message{{Role: schema.User, Content: "你好"}}))
+	msgs, err := s.Get(ctx, "测试群")
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+
+	// 消息列表为空时删除会话
+	require.NoError(t, s.Put(ctx, "测试群", nil))
+	msgs, err = s.Get(ctx, "测试群")
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+	require.Empty(t, logs.String())
+
+	// 已存在的存储桶 TTL 与配置不一致时记录差异，沿用线上配置
+	other, err := NewNatsKVStore(ctx, srv.URL(), "CHAT_HISTORY", 2*time.Hour)
+	require.NoError(t, err)
+	defer other.Close()
+	require.Contains(t, logs.String(), "Chat history bucket TTL differs from configured ttl")
+
+	// 两个进程并发追加同一会话时不会丢失对方的写入
+	var wg sync.WaitGroup
+	for _, store := range []*NatsKVStore{s, other} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 10 {
+				err := store.Update(ctx, "测试群", func(msgs []Message) ([]Message, error) {
+					return append(msgs, Message{Role: schema.User, Content: strconv.Itoa(i)}), nil
+				})
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	msgs, err = s.Get(ctx, "测试群")
+	require.NoError(t, err)
+	require.Len(t, msgs, 20)
+}
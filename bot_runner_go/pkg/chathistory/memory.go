@@ -0,0 +1,97 @@
+package chathistory
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+type memoryEntry struct {
+	key  string
+	msgs []Message
+}
+
+// MemoryStore 进程内的 LRU 存储，超出容量时淘汰最久未访问的会话
+type MemoryStore struct {
+	capacity int
+	mu       sync.Mutex
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	s.ll.MoveToFront(elem)
+	msgs := elem.Value.(*memoryEntry).msgs
+	return append([]Message(nil), msgs...), nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(key, msgs)
+	return nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(msgs []Message) ([]Message, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var msgs []Message
+	if elem, ok := s.items[key]; ok {
+		msgs = append(msgs, elem.Value.(*memoryEntry).msgs...)
+	}
+	msgs, err := fn(msgs)
+	if err != nil {
+		return err
+	}
+	s.put(key, msgs)
+	return nil
+}
+
+// put 写入会话的消息列表，调用方需持有锁
+func (s *MemoryStore) put(key string, msgs []Message) {
+	if len(msgs) == 0 {
+		if elem, ok := s.items[key]; ok {
+			s.ll.Remove(elem)
+			delete(s.items, key)
+		}
+		return
+	}
+
+	msgs = append([]Message(nil), msgs...)
+	if elem, ok := s.items[key]; ok {
+		elem.Value.(*memoryEntry).msgs = msgs
+		s.ll.MoveToFront(elem)
+		return
+	}
+	s.items[key] = s.ll.PushFront(&memoryEntry{key: key, msgs: msgs})
+
+	// 淘汰最久未访问的会话
+	for s.capacity > 0 && s.ll.Len() > s.capacity {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,166 @@
+package chathistory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsconn"
+)
+
+var _ Store = (*NatsKVStore)(nil)
+
+// NatsKVStore 基于 NATS JetStream KeyValue 的存储，存储桶的 TTL 与历史消息过期时间一致
+type NatsKVStore struct {
+	nc *nats.Conn
+	kv jetstream.KeyValue
+}
+
+func NewNatsKVStore(ctx context.Context, natsURL, bucket string, ttl time.Duration) (*NatsKVStore, error) {
+	logger := zerolog.Ctx(ctx).With().Str("bucket", bucket).Logger()
+
+	nc, err := natsconn.Connect(natsURL)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	// 存储桶不存在时自动创建，已存在时沿用线上配置，TTL 与配置不一致时记录差异
+	kv, err := js.KeyValue(ctx, bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:  bucket,
+			TTL:     ttl,
+			History: 1,
+			Storage: jetstream.FileStorage,
+		})
+	} else if err == nil {
+		var status jetstream.KeyValueStatus
+		if status, err = kv.Status(ctx); err == nil && status.TTL() != ttl {
+			logger.Warn().Dur("live_ttl", status.TTL()).Dur("desired_ttl", ttl).
+				Msg("Chat history bucket TTL differs from configured ttl, keeping live config")
+		}
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &NatsKVStore{nc: nc, kv: kv}, nil
+}
+
+// kvKey 会话名称可能包含中文等 KeyValue 不允许的字符，统一编码为 URL 安全的 base64
+func kvKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func (s *NatsKVStore) Get(ctx context.Context, key string) ([]Message, error) {
+	entry, err := s.kv.Get(ctx, kvKey(key))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var msgs []Message
+	if err := json.Unmarshal(entry.Value(), &msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func (s *NatsKVStore) Put(ctx context.Context, key string, msgs []Message) error {
+	if len(msgs) == 0 {
+		err := s.kv.Delete(ctx, kvKey(key))
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		return err
+	}
+	_, err = s.kv.Put(ctx, kvKey(key), data)
+	return err
+}
+
+// 并发写入冲突时重新读取并修改的最大次数
+const maxUpdateAttempts = 10
+
+func (s *NatsKVStore) Update(ctx context.Context, key string, fn func(msgs []Message) ([]Message, error)) error {
+	for attempt := range maxUpdateAttempts {
+		if attempt > 0 {
+			// 冲突后随机等待片刻再重新读取，避免多个进程步调一致地反复冲突
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(rand.N(time.Duration(attempt) * 10 * time.Millisecond)):
+			}
+		}
+
+		// 以读取时的版本号写回，期间其他进程写入过该会话时写入失败并重新读取
+		var msgs []Message
+		var revision uint64
+		entry, err := s.kv.Get(ctx, kvKey(key))
+		switch {
+		case errors.Is(err, jetstream.ErrKeyNotFound):
+		case err != nil:
+			return err
+		default:
+			revision = entry.Revision()
+			if err := json.Unmarshal(entry.Value(), &msgs); err != nil {
+				return err
+			}
+		}
+
+		msgs, err = fn(msgs)
+		if err != nil {
+			return err
+		}
+		err = s.write(ctx, key, revision, msgs)
+		if !isRevisionConflict(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to update chat history %q: too many concurrent writes", key)
+}
+
+// write 在会话仍为指定版本时写入消息列表，版本号为 0 表示会话不存在
+func (s *NatsKVStore) write(ctx context.Context, key string, revision uint64, msgs []Message) error {
+	if len(msgs) == 0 {
+		if revision == 0 {
+			return nil
+		}
+		return s.kv.Delete(ctx, kvKey(key), jetstream.LastRevision(revision))
+	}
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		return err
+	}
+	if revision == 0 {
+		_, err = s.kv.Create(ctx, kvKey(key), data)
+	} else {
+		_, err = s.kv.Update(ctx, kvKey(key), data, revision)
+	}
+	return err
+}
+
+// isRevisionConflict 判断写入是否因为会话的版本号已变化而失败，Create、Update 与 Delete 返回相同的错误码
+func isRevisionConflict(err error) bool {
+	return errors.Is(err, jetstream.ErrKeyExists)
+}
+
+func (s *NatsKVStore) Close() error {
+	return s.nc.Drain()
+}
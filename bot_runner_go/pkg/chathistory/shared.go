@@ -0,0 +1,78 @@
+package chathistory
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Stores 按存储配置复用存储，存储配置相同的多个 History 共享同一个存储：
+// 同一个 BoltDB 文件只能被打开一次，共享存储也使得同一会话换用其他配置时仍能读到之前的历史。
+// nats_kv 存储桶的 TTL 由第一个打开该存储的配置决定
+type Stores struct {
+	mu     sync.Mutex
+	stores map[StoreConfig]*sharedStore
+}
+
+func NewStores() *Stores {
+	return &Stores{stores: make(map[StoreConfig]*sharedStore)}
+}
+
+// New 创建使用共享存储的 History，History 关闭时释放对存储的引用，最后一个引用释放时关闭存储
+func (s *Stores) New(ctx context.Context, cfg *Config) (*History, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	store, err := s.open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &History{
+		cfg:   cfg,
+		store: store,
+		now:   time.Now,
+	}, nil
+}
+
+func (s *Stores) open(ctx context.Context, cfg *Config) (Store, error) {
+	key := cfg.Store
+	if key.Type == StoreTypeFile {
+		if path, err := filepath.Abs(key.Path); err == nil {
+			key.Path = path
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if shared, ok := s.stores[key]; ok {
+		shared.refs++
+		return shared, nil
+	}
+	store, err := newStore(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	shared := &sharedStore{Store: store, stores: s, key: key, refs: 1}
+	s.stores[key] = shared
+	return shared, nil
+}
+
+// sharedStore 带引用计数的共享存储
+type sharedStore struct {
+	Store
+	stores *Stores
+	key    StoreConfig
+	refs   int // 受 stores.mu 保护
+}
+
+func (s *sharedStore) Close() error {
+	s.stores.mu.Lock()
+	defer s.stores.mu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	delete(s.stores.stores, s.key)
+	return s.Store.Close()
+}
@@ -0,0 +1,72 @@
+package chathistory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Store 会话历史的持久化存储，只负责按会话键读写完整的消息列表，窗口与过期策略由 History 处理
+type Store interface {
+	// Get 读取会话的历史消息，会话不存在时返回空列表
+	Get(ctx context.Context, key string) ([]Message, error)
+	// Put 覆盖写入会话的历史消息，消息列表为空时删除该会话
+	Put(ctx context.Context, key string, msgs []Message) error
+	// Update 读取会话的历史消息交给 fn 修改后写回，期间会话被其他写入修改时重新执行 fn，多个进程共享存储时不会丢失更新
+	Update(ctx context.Context, key string, fn func(msgs []Message) ([]Message, error)) error
+	// Close 释放存储占用的资源
+	Close() error
+}
+
+type StoreType string
+
+const (
+	StoreTypeMemory StoreType = "memory"  // 进程内 LRU 缓存，重启后丢失
+	StoreTypeFile   StoreType = "file"    // 本地 BoltDB 文件
+	StoreTypeNatsKV StoreType = "nats_kv" // NATS JetStream KeyValue
+)
+
+type StoreConfig struct {
+	Type        StoreType `yaml:"type"`         // 存储类型：memory/file/nats_kv，默认 memory
+	MaxSessions int       `yaml:"max_sessions"` // memory：最多缓存的会话数量
+	Path        string    `yaml:"path"`         // file：BoltDB 文件路径
+	NatsURL     string    `yaml:"nats_url"`     // nats_kv：NATS 服务器地址，默认复用消费者的 nats_url
+	Bucket      string    `yaml:"bucket"`       // nats_kv：KeyValue 存储桶名称
+}
+
+func (c *StoreConfig) Validate() error {
+	if c.Type == "" {
+		c.Type = StoreTypeMemory
+	}
+	switch c.Type {
+	case StoreTypeMemory:
+		if c.MaxSessions <= 0 {
+			c.MaxSessions = 1000
+		}
+	case StoreTypeFile:
+		if c.Path == "" {
+			return errors.New("path is required for file history store")
+		}
+	case StoreTypeNatsKV:
+		if c.Bucket == "" {
+			c.Bucket = "CHAT_HISTORY"
+		}
+		if c.NatsURL == "" {
+			return errors.New("nats_url is required for nats_kv history store")
+		}
+	default:
+		return fmt.Errorf("unknown history store type: %s", c.Type)
+	}
+	return nil
+}
+
+func newStore(ctx context.Context, cfg *Config) (Store, error) {
+	switch cfg.Store.Type {
+	case StoreTypeFile:
+		return NewFileStore(cfg.Store.Path)
+	case StoreTypeNatsKV:
+		return NewNatsKVStore(ctx, cfg.Store.NatsURL, cfg.Store.Bucket, cfg.TTL)
+	default:
+		return NewMemoryStore(cfg.Store.MaxSessions), nil
+	}
+}
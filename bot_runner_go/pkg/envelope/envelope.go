@@ -0,0 +1,143 @@
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS 消息头名称
+const (
+	HeaderSchemaVersion = "Bot-Schema-Version" // 信封版本
+	HeaderMessageID     = "Bot-Message-Id"     // 消息 ID
+	HeaderTraceID       = "Bot-Trace-Id"       // 追踪 ID，回复沿用所回复消息的追踪 ID
+	HeaderBotAccount    = "Bot-Account"        // 消息所属的机器人账号
+	HeaderCreatedAt     = "Bot-Created-At"     // 消息创建时间，RFC3339Nano 格式
+	HeaderContentType   = "Content-Type"       // 消息体类型
+	HeaderInReplyTo     = "Bot-In-Reply-To"    // 所回复消息的消息 ID
+)
+
+// 当前的信封版本，未携带版本的消息视为旧版本的裸 JSON 消息
+const SchemaVersion = "1"
+
+const ContentTypeJSON = "application/json"
+
+var ErrUnsupportedVersion = errors.New("unsupported envelope schema version")
+
+// Envelope 随消息一起通过 NATS 消息头传递的元数据
+type Envelope struct {
+	SchemaVersion string    // 信封版本
+	MessageID     string    // 消息 ID
+	TraceID       string    // 追踪 ID
+	BotAccount    string    // 机器人账号
+	CreatedAt     time.Time // 创建时间
+	ContentType   string    // 消息体类型
+	InReplyTo     string    // 所回复消息的消息 ID，非回复消息为空
+}
+
+// New 创建一个新的信封，开始一条新的追踪链路
+func New(botAccount string) *Envelope {
+	return &Envelope{
+		SchemaVersion: SchemaVersion,
+		MessageID:     newID(),
+		TraceID:       newID(),
+		BotAccount:    botAccount,
+		CreatedAt:     time.Now(),
+		ContentType:   ContentTypeJSON,
+	}
+}
+
+// Reply 创建回复消息的信封，沿用追踪 ID 与机器人账号，并记录所回复的消息 ID
+func (e *Envelope) Reply() *Envelope {
+	return &Envelope{
+		SchemaVersion: SchemaVersion,
+		MessageID:     newID(),
+		TraceID:       e.TraceID,
+		BotAccount:    e.BotAccount,
+		CreatedAt:     time.Now(),
+		ContentType:   ContentTypeJSON,
+		InReplyTo:     e.MessageID,
+	}
+}
+
+// Write 把信封写入消息头
+func (e *Envelope) Write(h nats.Header) {
+	set := func(key, value string) {
+		if value != "" {
+			h.Set(key, value)
+		}
+	}
+	set(HeaderSchemaVersion, e.SchemaVersion)
+	set(HeaderMessageID, e.MessageID)
+	set(HeaderTraceID, e.TraceID)
+	set(HeaderBotAccount, e.BotAccount)
+	if !e.CreatedAt.IsZero() {
+		set(HeaderCreatedAt, e.CreatedAt.Format(time.RFC3339Nano))
+	}
+	set(HeaderContentType, e.ContentType)
+	set(HeaderInReplyTo, e.InReplyTo)
+}
+
+// Read 从消息头读取信封。未携带信封的旧消息按 JSON 消息处理并分配新的消息 ID 与追踪 ID，
+// 版本不受支持时返回 ErrUnsupportedVersion
+func Read(h nats.Header) (*Envelope, error) {
+	version := h.Get(HeaderSchemaVersion)
+	if version == "" {
+		e := New(h.Get(HeaderBotAccount))
+		e.SchemaVersion = ""
+		return e, nil
+	}
+	if version != SchemaVersion {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
+	}
+
+	e := &Envelope{
+		SchemaVersion: version,
+		MessageID:     h.Get(HeaderMessageID),
+		TraceID:       h.Get(HeaderTraceID),
+		BotAccount:    h.Get(HeaderBotAccount),
+		ContentType:   h.Get(HeaderContentType),
+		InReplyTo:     h.Get(HeaderInReplyTo),
+	}
+	if v := h.Get(HeaderCreatedAt); v != "" {
+		createdAt, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %w", HeaderCreatedAt, err)
+		}
+		e.CreatedAt = createdAt
+	}
+	if e.MessageID == "" {
+		e.MessageID = newID()
+	}
+	if e.TraceID == "" {
+		e.TraceID = newID()
+	}
+	if e.ContentType == "" {
+		e.ContentType = ContentTypeJSON
+	}
+	return e, nil
+}
+
+type ctxKeyEnvelope struct{}
+
+// WithContext 在 context 中记录正在处理的消息的信封，生产者据此生成回复的信封
+func WithContext(ctx context.Context, e *Envelope) context.Context {
+	return context.WithValue(ctx, ctxKeyEnvelope{}, e)
+}
+
+// FromContext 获取正在处理的消息的信封
+func FromContext(ctx context.Context) (*Envelope, bool) {
+	e, ok := ctx.Value(ctxKeyEnvelope{}).(*Envelope)
+	return e, ok
+}
+
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,52 @@
+package envelope
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	in := New("bot")
+	h := nats.Header{}
+	in.Write(h)
+
+	got, err := Read(h)
+	require.NoError(t, err)
+	require.Equal(t, in.MessageID, got.MessageID)
+	require.Equal(t, in.TraceID, got.TraceID)
+	require.Equal(t, "bot", got.BotAccount)
+	require.Equal(t, ContentTypeJSON, got.ContentType)
+	require.True(t, in.CreatedAt.Equal(got.CreatedAt))
+
+	// 回复沿用追踪 ID 并记录所回复的消息
+	reply := got.Reply()
+	require.Equal(t, in.TraceID, reply.TraceID)
+	require.Equal(t, in.MessageID, reply.InReplyTo)
+	require.NotEqual(t, in.MessageID, reply.MessageID)
+}
+
+func TestReadLegacyMessage(t *testing.T) {
+	// 未携带信封的旧消息视为 JSON 消息，并分配追踪 ID
+	got, err := Read(nats.Header{})
+	require.NoError(t, err)
+	require.Empty(t, got.SchemaVersion)
+	require.NotEmpty(t, got.TraceID)
+	require.Equal(t, ContentTypeJSON, got.ContentType)
+}
+
+func TestReadUnsupportedVersion(t *testing.T) {
+	h := nats.Header{}
+	h.Set(HeaderSchemaVersion, "99")
+	_, err := Read(h)
+	require.True(t, errors.Is(err, ErrUnsupportedVersion))
+
+	h = nats.Header{}
+	h.Set(HeaderSchemaVersion, SchemaVersion)
+	h.Set(HeaderCreatedAt, time.Now().Format(time.Kitchen))
+	_, err = Read(h)
+	require.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package natsconn
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// InProcessScheme 进程内连接的 URL 协议，inprocess://<name> 连接以 name 注册的进程内服务器
+const InProcessScheme = "inprocess"
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]nats.InProcessConnProvider{}
+)
+
+// RegisterInProcess 注册进程内服务器，之后可以通过 inprocess://<name> 连接
+func RegisterInProcess(name string, provider nats.InProcessConnProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[name] = provider
+}
+
+// UnregisterInProcess 取消注册进程内服务器
+func UnregisterInProcess(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(providers, name)
+}
+
+// Connect 连接到 NATS 服务器，url 为 inprocess://<name> 时连接已注册的进程内服务器，不经过网络
+func Connect(url string, opts ...nats.Option) (*nats.Conn, error) {
+	name, ok := strings.CutPrefix(url, InProcessScheme+"://")
+	if !ok {
+		return nats.Connect(url, opts...)
+	}
+
+	mu.RLock()
+	provider, ok := providers[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("in-process nats server %q is not registered", name)
+	}
+	return nats.Connect("", append(opts, nats.InProcessServer(provider))...)
+}
@@ -2,15 +2,90 @@ package natsconsumer
 
 import (
 	"errors"
+	"fmt"
 	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type ConsumerMode string
+
+const (
+	ConsumerModeDurable ConsumerMode = "durable" // 绑定已有的持久化消费者，默认
+	ConsumerModeOrdered ConsumerMode = "ordered" // 创建临时有序消费者，按流中的顺序重放消息，不确认消息，用于重放与调试
 )
 
 type Config struct {
 	NatsURL      string        `yaml:"nats_url"`
 	Concurrency  int           `yaml:"concurrency"`
 	Subject      string        `yaml:"subject"`
-	ConsumerName string        `yaml:"consumer_name"`
-	PullMaxWait  time.Duration `yaml:"pull_max_wait"`
+	ConsumerName string        `yaml:"consumer_name"` // 持久化消费者名称，ordered 模式下不需要
+	PullMaxWait  time.Duration `yaml:"pull_max_wait"` // 每次拉取请求的最长等待时间，至少 1s
+	BatchSize    int           `yaml:"batch_size"`    // 客户端最多预取的消息数，同时也是已接收待处理消息数的上限（不含按顺序键排队的消息），默认与 concurrency 相同
+
+	MaxPendingPerKey int           `yaml:"max_pending_per_key"` // 每个顺序键排队等待处理的消息数上限，达到后该顺序键的新消息延迟 requeue_delay 重新投递，默认 100
+	MaxQueueWait     time.Duration `yaml:"max_queue_wait"`      // 消息按顺序键排队等待的最长时间，超过后延迟 requeue_delay 重新投递，不再为其发送 InProgress，默认 2m
+	RequeueDelay     time.Duration `yaml:"requeue_delay"`       // 排队已满、等待超时的消息以及重试计划延迟为 0 时死信发布失败的消息重新投递前的延迟，重新投递计入投递次数，默认 5s
+
+	Mode    ConsumerMode  `yaml:"mode"`    // 消费模式 durable/ordered，默认 durable
+	Stream  string        `yaml:"stream"`  // 消息所在的流，为空时根据 subject 查找
+	Ordered OrderedConfig `yaml:"ordered"` // ordered 模式的重放起点
+
+	InProgressInterval time.Duration `yaml:"in_progress_interval"` // 处理消息期间发送 InProgress 的间隔，应小于消费者的 AckWait，默认 10s
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout"`     // 停止时等待正在处理的消息完成并确认的最长时间，超时后取消处理，默认 20s
+
+	DeadLetter    DeadLetterConfig `yaml:"dead_letter"`    // 死信配置
+	RetrySchedule []time.Duration  `yaml:"retry_schedule"` // 处理失败后按投递次数依次使用的重新投递延迟，超出部分使用最后一项
+}
+
+var defaultRetrySchedule = []time.Duration{1 * time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+type DeadLetterConfig struct {
+	Subject    string `yaml:"subject"`     // 死信主题，需被某个流捕获，为空时不转发死信
+	MaxDeliver int    `yaml:"max_deliver"` // 最大投递次数，达到后仍处理失败的消息转入死信，0 表示不限制
+}
+
+// OrderedConfig 临时有序消费者的重放起点，start_sequence 与 start_time 优先于 deliver_policy
+type OrderedConfig struct {
+	DeliverPolicy string     `yaml:"deliver_policy"` // all/last/new，默认 all
+	StartSequence uint64     `yaml:"start_sequence"` // 从指定的流序号开始重放
+	StartTime     *time.Time `yaml:"start_time"`     // 从指定时间开始重放
+}
+
+var deliverPolicies = map[string]jetstream.DeliverPolicy{
+	"all":  jetstream.DeliverAllPolicy,
+	"last": jetstream.DeliverLastPolicy,
+	"new":  jetstream.DeliverNewPolicy,
+}
+
+func (c *OrderedConfig) Validate() error {
+	if c.DeliverPolicy == "" {
+		c.DeliverPolicy = "all"
+	}
+
+	if _, ok := deliverPolicies[c.DeliverPolicy]; !ok {
+		return fmt.Errorf("ordered.deliver_policy %q is not supported", c.DeliverPolicy)
+	}
+	if c.StartSequence > 0 && c.StartTime != nil {
+		return errors.New("ordered.start_sequence and ordered.start_time are mutually exclusive")
+	}
+	return nil
+}
+
+func (c *OrderedConfig) consumerConfig(subject string) jetstream.OrderedConsumerConfig {
+	cfg := jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{subject},
+		DeliverPolicy:  deliverPolicies[c.DeliverPolicy],
+	}
+	switch {
+	case c.StartSequence > 0:
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = c.StartSequence
+	case c.StartTime != nil:
+		cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		cfg.OptStartTime = c.StartTime
+	}
+	return cfg
 }
 
 func (c *Config) Validate() error {
@@ -20,6 +95,30 @@ func (c *Config) Validate() error {
 	if c.PullMaxWait <= 0 {
 		c.PullMaxWait = 1 * time.Second
 	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = c.Concurrency
+	}
+	if c.MaxPendingPerKey <= 0 {
+		c.MaxPendingPerKey = 100
+	}
+	if c.MaxQueueWait <= 0 {
+		c.MaxQueueWait = 2 * time.Minute
+	}
+	if c.RequeueDelay <= 0 {
+		c.RequeueDelay = 5 * time.Second
+	}
+	if c.InProgressInterval <= 0 {
+		c.InProgressInterval = 10 * time.Second
+	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = 20 * time.Second
+	}
+	if len(c.RetrySchedule) == 0 {
+		c.RetrySchedule = append([]time.Duration(nil), defaultRetrySchedule...)
+	}
+	if c.Mode == "" {
+		c.Mode = ConsumerModeDurable
+	}
 
 	if c.NatsURL == "" {
 		return errors.New("nats_url is required")
@@ -27,14 +126,43 @@ func (c *Config) Validate() error {
 	if c.Subject == "" {
 		return errors.New("subject is required")
 	}
-	if c.ConsumerName == "" {
-		return errors.New("consumer_name is required")
+	switch c.Mode {
+	case ConsumerModeDurable:
+		if c.ConsumerName == "" {
+			return errors.New("consumer_name is required")
+		}
+	case ConsumerModeOrdered:
+		if err := c.Ordered.Validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("mode %q is not supported", c.Mode)
 	}
 	if c.Concurrency <= 0 {
 		return errors.New("concurrency must be greater than 0")
 	}
-	if c.PullMaxWait <= 0 {
-		return errors.New("pull_max_wait must be greater than 0")
+	if c.PullMaxWait < time.Second {
+		return errors.New("pull_max_wait must be at least 1s")
+	}
+	for _, d := range c.RetrySchedule {
+		if d < 0 {
+			return errors.New("retry_schedule must not contain negative durations")
+		}
+	}
+	if c.DeadLetter.MaxDeliver < 0 {
+		return errors.New("dead_letter.max_deliver must not be negative")
+	}
+	if c.DeadLetter.Subject != "" && c.DeadLetter.Subject == c.Subject {
+		return errors.New("dead_letter.subject must differ from subject")
 	}
 	return nil
 }
+
+// retryDelay 返回第 numDelivered 次投递处理失败后的重新投递延迟
+func (c *Config) retryDelay(numDelivered uint64) time.Duration {
+	if len(c.RetrySchedule) == 0 {
+		return 0
+	}
+	i := min(max(numDelivered, 1)-1, uint64(len(c.RetrySchedule)-1))
+	return c.RetrySchedule[i]
+}
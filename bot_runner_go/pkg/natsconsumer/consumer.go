@@ -3,11 +3,18 @@ package natsconsumer
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/envelope"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsconn"
 )
 
 type ctxKeyWorkerID struct{}
@@ -19,156 +26,595 @@ func MustGetWorkerID(ctx context.Context) int {
 	panic("worker ID not found in context")
 }
 
-type HandleResult string
+type HandleAction string
 
 const (
-	HandleResultAck  HandleResult = "ACK"  // 处理成功，消息已确认
-	HandleResultNak  HandleResult = "NAK"  // 处理失败，消息重新入队
-	HandleResultTerm HandleResult = "TERM" // 处理失败，消息丢弃
+	HandleActionAck  HandleAction = "ACK"  // 处理成功，消息已确认
+	HandleActionNak  HandleAction = "NAK"  // 处理失败，消息重新入队
+	HandleActionTerm HandleAction = "TERM" // 处理失败，消息丢弃
 )
 
+type HandleResult struct {
+	Action    HandleAction  // 处理结果
+	Delay     time.Duration // NAK 时重新投递前的延迟，为 0 时立即重新投递
+	scheduled bool          // NAK 时按消费者的重试计划计算延迟
+}
+
+var (
+	HandleResultAck   = HandleResult{Action: HandleActionAck}                  // 处理成功，消息已确认
+	HandleResultNak   = HandleResult{Action: HandleActionNak}                  // 处理失败，消息立即重新入队
+	HandleResultTerm  = HandleResult{Action: HandleActionTerm}                 // 处理失败，消息丢弃
+	HandleResultRetry = HandleResult{Action: HandleActionNak, scheduled: true} // 处理失败，按重试计划延迟重新投递
+)
+
+// NakWithDelay 处理失败，消息在指定延迟后重新投递
+func NakWithDelay(delay time.Duration) HandleResult {
+	return HandleResult{Action: HandleActionNak, Delay: delay}
+}
+
 type HandlerFunc func(ctx context.Context, msg *nats.Msg) HandleResult
 
 type Consumer struct {
-	cfg *Config
+	cfg         *Config
+	orderingKey OrderingKeyFunc // 消息顺序键，为 nil 时不保证顺序
+
+	nc    atomic.Pointer[nats.Conn] // 运行期间的 NATS 连接
+	ready atomic.Bool               // 本次运行中是否已绑定过消费者
+
+	mu   sync.Mutex
+	cons jetstream.Consumer // 当前绑定的消费者，未绑定时为 nil
 }
 
-func New(cfg *Config) *Consumer {
-	return &Consumer{
+type Option func(*Consumer)
+
+// WithOrderingKey 指定消息顺序键，顺序键相同的消息依次处理
+func WithOrderingKey(fn OrderingKeyFunc) Option {
+	return func(c *Consumer) {
+		c.orderingKey = fn
+	}
+}
+
+func New(cfg *Config, opts ...Option) *Consumer {
+	c := &Consumer{
 		cfg: cfg,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Ready 是否已连接 NATS 并绑定消费者，开始接收消息
+func (c *Consumer) Ready() bool {
+	return c.ready.Load()
+}
+
+// Health 检查 NATS 连接状态与消费者是否仍然绑定
+func (c *Consumer) Health() error {
+	nc := c.nc.Load()
+	if nc == nil {
+		return errors.New("consumer is not running")
+	}
+	if status := nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection is %s", status)
+	}
+	if c.bound() == nil {
+		return errors.New("consumer is not bound")
+	}
+	return nil
+}
+
+// Status 消费者的运行状态
+type Status struct {
+	Connection     string     `json:"connection"`            // NATS 连接状态
+	Bound          bool       `json:"bound"`                 // 是否已绑定消费者
+	Stream         string     `json:"stream,omitempty"`      // 消费者所在的流
+	Consumer       string     `json:"consumer,omitempty"`    // 消费者名称，有序消费者为服务器生成的名称
+	NumPending     uint64     `json:"num_pending"`           // 尚未投递的消息数，即消费滞后
+	NumAckPending  int        `json:"num_ack_pending"`       // 已投递尚未确认的消息数
+	NumRedelivered int        `json:"num_redelivered"`       // 重新投递过的消息数
+	LastActive     *time.Time `json:"last_active,omitempty"` // 最近一次投递消息的时间
+	Error          string     `json:"error,omitempty"`       // 获取消费者信息失败的原因
+}
+
+// Status 返回 NATS 连接状态，已绑定消费者时从服务器获取消费滞后等信息
+func (c *Consumer) Status(ctx context.Context) Status {
+	var st Status
+	if nc := c.nc.Load(); nc != nil {
+		st.Connection = nc.Status().String()
+	} else {
+		st.Connection = "NOT_RUNNING"
+	}
+	cons := c.bound()
+	if cons == nil {
+		return st
+	}
+	st.Bound = true
+	info, err := cons.Info(ctx)
+	if err != nil {
+		// 获取失败时使用绑定时缓存的信息
+		st.Error = err.Error()
+		if info = cons.CachedInfo(); info == nil {
+			return st
+		}
+	}
+	st.Stream = info.Stream
+	st.Consumer = info.Name
+	st.NumPending = info.NumPending
+	st.NumAckPending = info.NumAckPending
+	st.NumRedelivered = info.NumRedelivered
+	st.LastActive = info.Delivered.Last
+	return st
 }
 
+func (c *Consumer) bound() jetstream.Consumer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cons
+}
+
+func (c *Consumer) setBound(cons jetstream.Consumer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cons = cons
+}
+
+// Run 绑定持久化消费者（ordered 模式下创建临时有序消费者），持续接收消息并分发给固定数量的工作协程处理，直到 ctx 取消
 func (c *Consumer) Run(ctx context.Context, handler HandlerFunc) (err error) {
+	if err = c.cfg.Validate(); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("Invalid consumer config")
+		return
+	}
+
 	logger := zerolog.Ctx(ctx).With().
 		Str("consumer_name", c.cfg.ConsumerName).
 		Str("subject", c.cfg.Subject).
+		Str("mode", string(c.cfg.Mode)).
 		Logger()
+	ctx = logger.WithContext(ctx)
 
 	// 连接到NATS服务器
-	nc, err := nats.Connect(c.cfg.NatsURL)
+	nc, err := natsconn.Connect(c.cfg.NatsURL)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to connect to NATS server")
 		return
 	}
+	c.nc.Store(nc)
 	defer func() {
+		c.ready.Store(false)
+		c.setBound(nil)
+		c.nc.Store(nil)
 		if err := nc.Drain(); err != nil {
 			logger.Error().Err(err).Msg("Failed to drain NATS connection")
 		}
 	}()
 	// 创建JetStream上下文
-	js, err := nc.JetStream()
+	js, err := jetstream.New(nc)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to create JetStream context")
 		return
 	}
 
+	// slots 限制已接收待处理的消息数，queue 的容量与之相同，分发任务不会阻塞；
+	// 按顺序键排队的消息不占用 slots，某个会话的消息积压时不影响其他会话，每个顺序键的排队数由 max_pending_per_key 限制，
+	// 排队已满或等待超过 max_queue_wait 的消息延迟重新投递，不会阻塞接收
+	slots := make(chan struct{}, c.cfg.BatchSize)
+	queue := newOrderedQueue(c.cfg.BatchSize, c.cfg.MaxPendingPerKey)
+
+	// 停止时不再接收与分发新消息，正在处理的消息继续处理并确认，超过 shutdown_timeout 后取消处理
+	handleCtx, cancelHandle := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandle()
+	shutdownTimer := make(chan *time.Timer, 1)
+	stopHandle := context.AfterFunc(ctx, func() {
+		shutdownTimer <- time.AfterFunc(c.cfg.ShutdownTimeout, cancelHandle)
+	})
+	// 处理结束后停止计时器，避免 Run 返回后计时器仍然存活
+	defer func() {
+		if !stopHandle() {
+			(<-shutdownTimer).Stop()
+		}
+	}()
+
 	var wg sync.WaitGroup
+	if !c.ordered() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.expireQueued(ctx, queue)
+		}()
+	}
 	for i := range c.cfg.Concurrency {
 		wg.Add(1)
-		logger.Info().Msgf("Starting consumer worker %d", i)
 		go func(i int) {
 			defer wg.Done()
 			logger := logger.With().Int("worker_id", i).Logger()
-			ctx := logger.WithContext(ctx)
-			c.consumerWorker(ctx, js, i, handler)
+			c.worker(logger.WithContext(ctx), logger.WithContext(handleCtx), js, i, queue, slots, handler)
 		}(i)
 	}
 	logger.Info().Msgf("Started %d consumer workers for subject %s", c.cfg.Concurrency, c.cfg.Subject)
+
+	c.consumeLoop(ctx, js, queue, slots)
 	wg.Wait()
+
+	// 尚未开始处理的消息立即重新投递
+	for _, j := range queue.Drain() {
+		j.stop()
+		c.release(ctx, j.msg)
+	}
+	logger.Info().Msg("Consumer stopped")
 	return
 }
 
-// isConnectionError 检查错误是否是连接相关的错误
-func isConnectionError(err error) bool {
-	return errors.Is(err, nats.ErrConnectionClosed) ||
-		errors.Is(err, nats.ErrNoResponders) ||
-		errors.Is(err, nats.ErrBadSubscription)
+// ordered 是否为临时有序消费者，有序消费者不需要确认消息
+func (c *Consumer) ordered() bool {
+	return c.cfg.Mode == ConsumerModeOrdered
+}
+
+// bind 绑定已有的持久化消费者，ordered 模式下创建临时有序消费者
+func (c *Consumer) bind(ctx context.Context, js jetstream.JetStream) (jetstream.Consumer, error) {
+	stream := c.cfg.Stream
+	if stream == "" {
+		name, err := js.StreamNameBySubject(ctx, c.cfg.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find stream for subject %s: %w", c.cfg.Subject, err)
+		}
+		stream = name
+	}
+	if c.ordered() {
+		return js.OrderedConsumer(ctx, stream, c.cfg.Ordered.consumerConfig(c.cfg.Subject))
+	}
+	return js.Consumer(ctx, stream, c.cfg.ConsumerName)
 }
 
-func (c *Consumer) consumerWorker(ctx context.Context, js nats.JetStreamContext, workerID int, handler HandlerFunc) {
+// consumeLoop 持续接收消息，消费者或消息迭代器失效时重新创建，直到 ctx 取消
+func (c *Consumer) consumeLoop(ctx context.Context, js jetstream.JetStream, queue *orderedQueue, slots chan struct{}) {
 	logger := zerolog.Ctx(ctx)
 
-	// 创建订阅者
-	var sub *nats.Subscription
-	defer func() {
-		if sub != nil {
-			if err := sub.Unsubscribe(); err != nil {
-				logger.Error().Err(err).Msg("Failed to unsubscribe from subject")
-			} else {
-				logger.Info().Msg("Unsubscribed from subject")
+	var cons jetstream.Consumer
+	for ctx.Err() == nil {
+		if cons == nil {
+			var err error
+			if cons, err = c.bind(ctx, js); err != nil {
+				logger.Error().Err(err).Msg("Failed to bind consumer")
+				c.backoff(ctx)
+				continue
 			}
+			logger.Info().Msgf("Bound to consumer %s on stream %s", cons.CachedInfo().Name, cons.CachedInfo().Stream)
+			c.setBound(cons)
+			c.ready.Store(true)
 		}
-	}()
 
-	// 开始消费
+		if err := c.receive(ctx, cons, queue, slots); err != nil {
+			// 有序消费者会从上次接收的位置继续，持久化消费者重新绑定以便发现被删除的情况
+			logger.Error().Err(err).Msg("Failed to receive messages")
+			if !c.ordered() {
+				cons = nil
+				c.setBound(nil)
+			}
+			c.backoff(ctx)
+		}
+	}
+	logger.Info().Msg("Consumer receive loop stopping")
+}
+
+func (c *Consumer) backoff(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+	}
+}
+
+// receive 有空闲的处理名额时从消息迭代器接收消息并分发，ctx 取消时返回 nil，迭代器出错时返回错误
+func (c *Consumer) receive(ctx context.Context, cons jetstream.Consumer, queue *orderedQueue, slots chan struct{}) error {
+	// 客户端最多预取 batch_size 条消息，每次拉取请求最多等待 pull_max_wait
+	iter, err := cons.Messages(
+		jetstream.PullMaxMessages(c.cfg.BatchSize),
+		jetstream.PullExpiry(c.cfg.PullMaxWait),
+	)
+	if err != nil {
+		return err
+	}
+	defer iter.Stop()
+	// ctx 取消时停止拉取，已预取的消息仍可由 Next 取出，随后返回 ErrMsgIteratorClosed
+	stop := context.AfterFunc(ctx, iter.Drain)
+	defer stop()
+
 	for {
 		select {
 		case <-ctx.Done():
-			logger.Info().Msg("Consumer worker stopping")
-			return
-		default:
+			c.releaseAll(ctx, iter)
+			return nil
+		case slots <- struct{}{}:
 		}
 
-		if sub != nil && !sub.IsValid() { // 如果订阅者无效，则重新创建
-			logger.Warn().Msg("Subscription is invalid, recreating")
-			sub = nil
+		msg, err := iter.Next()
+		if err != nil {
+			<-slots
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return errors.New("message iterator closed unexpectedly")
+			}
+			return err
+		}
+		messagesFetched.WithLabelValues(c.metricsName()).Inc()
+		if ctx.Err() != nil {
+			<-slots
+			c.release(ctx, msg)
+			c.releaseAll(ctx, iter)
+			return nil
 		}
 
-		if sub == nil { // 如果订阅者不存在，则创建一个新的消息订阅者
-			// 订阅消息
-			var err error
-			sub, err = js.PullSubscribe(c.cfg.Subject, c.cfg.ConsumerName, nats.ManualAck())
-			if err != nil {
-				logger.Error().Err(err).Msg("Failed to subscribe to subject")
-				time.Sleep(2 * time.Second)
-				continue
+		var key string
+		natsMsg := toNatsMsg(msg)
+		if c.orderingKey != nil {
+			key = c.orderingKey(natsMsg)
+		}
+		// 从接收时开始发送 InProgress，覆盖排队等待的时间
+		j := &job{key: key, msg: msg, natsMsg: natsMsg, stop: c.keepInProgress(ctx, msg)}
+		queued, err := queue.Submit(j)
+		for errors.Is(err, errKeyQueueFull) && c.ordered() {
+			// 有序消费者无法重新投递，只能等待该顺序键有空位
+			if err = queue.Wait(ctx, key); err != nil {
+				break
 			}
-			logger.Info().Msgf("Subscribed to subject %s with consumer %s", c.cfg.Subject, c.cfg.ConsumerName)
+			queued, err = queue.Submit(j)
+		}
+		switch {
+		case errors.Is(err, errKeyQueueFull):
+			// 该顺序键排队已满，稍后重新投递，不阻塞其他顺序键的消息
+			zerolog.Ctx(ctx).Warn().Str("ordering_key", key).Msg("Ordering key queue is full, message will be redelivered later")
+			<-slots
+			j.stop()
+			c.requeue(ctx, msg)
+			continue
+		case err != nil:
+			// 停止时仍在等待排队的消息立即重新投递
+			<-slots
+			j.stop()
+			c.release(ctx, msg)
+			c.releaseAll(ctx, iter)
+			return nil
+		}
+		if queued {
+			// 排队的消息不占用处理名额，轮到它处理时沿用前一条消息的名额
+			<-slots
 		}
+	}
+}
 
-		// 拉取消息
-		msgs, err := sub.Fetch(1, nats.MaxWait(c.cfg.PullMaxWait))
+// releaseAll 取出迭代器中已预取的全部消息并立即重新投递，需在迭代器 Drain 之后调用
+func (c *Consumer) releaseAll(ctx context.Context, iter jetstream.MessagesContext) {
+	for {
+		msg, err := iter.Next()
 		if err != nil {
-			if errors.Is(err, nats.ErrTimeout) {
-				continue
-			}
-			logger.Error().Err(err).Msg("Failed to fetch messages")
+			return
+		}
+		c.release(ctx, msg)
+	}
+}
+
+// release 放弃处理消息，持久化消费者立即重新投递
+func (c *Consumer) release(ctx context.Context, msg jetstream.Msg) {
+	if c.ordered() {
+		return
+	}
+	messagesSettled.WithLabelValues(c.metricsName(), "nak").Inc()
+	if err := msg.Nak(); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to Nak message")
+	}
+}
 
-			if isConnectionError(err) {
-				logger.Warn().Err(err).Msg("Bad subscription, will recreate")
-				sub = nil // 重置订阅者
+// requeue 放弃处理消息，延迟 requeue_delay 后重新投递
+func (c *Consumer) requeue(ctx context.Context, msg jetstream.Msg) {
+	messagesSettled.WithLabelValues(c.metricsName(), "nak").Inc()
+	if err := msg.NakWithDelay(c.cfg.RequeueDelay); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to Nak message")
+	}
+}
+
+// expireQueued 定期取出排队超过 max_queue_wait 的消息并延迟重新投递，避免某条消息长时间处理不完时，
+// 其后排队的消息一直发送 InProgress 而占用消费者，直到 ctx 取消
+func (c *Consumer) expireQueued(ctx context.Context, queue *orderedQueue) {
+	logger := zerolog.Ctx(ctx)
+	ticker := time.NewTicker(min(c.cfg.InProgressInterval, c.cfg.MaxQueueWait))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, j := range queue.Expire(time.Now().Add(-c.cfg.MaxQueueWait)) {
+				logger.Warn().Str("ordering_key", j.key).Msg("Message waited too long in ordering key queue, it will be redelivered later")
+				j.stop()
+				c.requeue(ctx, j.msg)
 			}
-			continue
 		}
+	}
+}
 
-		// 消息处理
-		for _, msg := range msgs {
-			if handler == nil {
-				logger.Warn().Msg("No handler set, message will be requeued")
-				if err := msg.Nak(); err != nil {
-					logger.Error().Err(err).Msg("Failed to Nak message")
-				}
+// toNatsMsg 把 jetstream 消息转换为传给处理函数的 *nats.Msg，可以读取 Metadata，
+// 但消息由消费者根据 HandleResult 确认，转换后的消息绑定的是没有连接的订阅，直接确认会返回错误
+func toNatsMsg(msg jetstream.Msg) *nats.Msg {
+	return &nats.Msg{
+		Subject: msg.Subject(),
+		Reply:   msg.Reply(),
+		Header:  msg.Headers(),
+		Data:    msg.Data(),
+		Sub:     &nats.Subscription{},
+	}
+}
+
+// worker 依次处理分发到的消息，直到 ctx 取消，消息的处理与确认使用 handleCtx，不随 ctx 取消而中断
+func (c *Consumer) worker(ctx context.Context, handleCtx context.Context, js jetstream.JetStream, workerID int, queue *orderedQueue, slots chan struct{}, handler HandlerFunc) {
+	logger := zerolog.Ctx(ctx)
+	handleCtx = context.WithValue(handleCtx, ctxKeyWorkerID{}, workerID)
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Consumer worker stopping")
+			return
+		case j := <-queue.jobs:
+			if ctx.Err() != nil {
+				// 停止后不再开始处理新消息，立即重新投递
+				j.stop()
+				c.release(ctx, j.msg)
+				c.done(queue, j, slots)
 				continue
 			}
+			c.handle(handleCtx, js, j, handler)
+			c.done(queue, j, slots)
+		}
+	}
+}
+
+// done 任务处理完成，同一顺序键的下一个任务接替处理名额，没有排队的任务时释放名额
+func (c *Consumer) done(queue *orderedQueue, j *job, slots chan struct{}) {
+	if !queue.Done(j) {
+		<-slots
+	}
+}
+
+// handle 处理一条消息并根据处理结果确认
+func (c *Consumer) handle(ctx context.Context, js jetstream.JetStream, j *job, handler HandlerFunc) {
+	logger := zerolog.Ctx(ctx)
+	msg := j.msg
+	settle := func(ctx context.Context, result HandleResult, reason string) {
+		j.stop()
+		c.settle(ctx, js, msg, result, reason)
+	}
 
-			func() {
-				ctx = context.WithValue(ctx, ctxKeyWorkerID{}, workerID)
-				switch result := handler(ctx, msg); result {
-				case HandleResultAck:
-					if err := msg.Ack(); err != nil {
-						logger.Error().Err(err).Msg("Failed to Ack message")
-					}
-				case HandleResultNak:
-					if err := msg.Nak(); err != nil {
-						logger.Error().Err(err).Msg("Failed to Nak message")
-					}
-				case HandleResultTerm:
-					if err := msg.Term(); err != nil {
-						logger.Error().Err(err).Msg("Failed to Term message")
-					}
+	if handler == nil {
+		logger.Warn().Msg("No handler set, message will be requeued")
+		settle(ctx, HandleResultNak, "")
+		return
+	}
+
+	// 读取消息信封，版本不受支持的消息无法正确处理，直接丢弃
+	env, err := envelope.Read(msg.Headers())
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to read message envelope, terminating message")
+		settle(ctx, HandleResultTerm, "invalid envelope: "+err.Error())
+		return
+	}
+	l := logger.With().Str("trace_id", env.TraceID).Str("message_id", env.MessageID).Logger()
+	if j.key != "" {
+		l = l.With().Str("ordering_key", j.key).Logger()
+	}
+	ctx = envelope.WithContext(l.WithContext(ctx), env)
+
+	// 投递次数已超过上限的消息（如处理超时未确认）不再处理
+	if maxDeliver := c.cfg.DeadLetter.MaxDeliver; maxDeliver > 0 && numDelivered(msg) > uint64(maxDeliver) {
+		settle(ctx, HandleResultNak, "")
+		return
+	}
+
+	reason := &failureReason{}
+	started := time.Now()
+	result := handler(context.WithValue(ctx, ctxKeyFailureReason{}, reason), j.natsMsg)
+	handlerDuration.WithLabelValues(c.metricsName(), strconv.Itoa(MustGetWorkerID(ctx))).Observe(time.Since(started).Seconds())
+	settle(ctx, result, reason.reason)
+}
+
+// keepInProgress 在消息排队与处理期间定期发送 InProgress 重置 AckWait 计时，避免耗时较长的消息被重复投递，
+// 返回的函数用于停止发送，需在处理结束后调用
+func (c *Consumer) keepInProgress(ctx context.Context, msg jetstream.Msg) (stop func()) {
+	if c.ordered() {
+		return func() {}
+	}
+
+	logger := zerolog.Ctx(ctx)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(c.cfg.InProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := msg.InProgress(); err != nil {
+					logger.Warn().Err(err).Msg("Failed to send InProgress")
+					continue
 				}
-			}()
+				logger.Debug().Msg("Sent InProgress")
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// settle 根据处理结果确认消息，终止的消息与达到最大投递次数仍处理失败的消息转入死信主题，
+// 有序消费者不确认消息，只记录处理结果
+func (c *Consumer) settle(ctx context.Context, js jetstream.JetStream, msg jetstream.Msg, result HandleResult, reason string) {
+	logger := zerolog.Ctx(ctx)
+
+	if c.ordered() {
+		if result.Action != HandleActionAck {
+			logger.Warn().Str("result", string(result.Action)).Str("reason", reason).Msg("Ordered consumer does not redeliver or dead letter messages")
+		}
+		return
+	}
+
+	var dlqResult string
+	switch {
+	case result.Action == HandleActionTerm:
+		dlqResult = string(HandleActionTerm)
+	case result.Action == HandleActionNak && c.cfg.DeadLetter.MaxDeliver > 0 &&
+		numDelivered(msg) >= uint64(c.cfg.DeadLetter.MaxDeliver):
+		dlqResult = deadLetterResultMaxDeliver
+		result = HandleResultTerm
+		if reason == "" {
+			reason = fmt.Sprintf("exceeded max deliver %d", c.cfg.DeadLetter.MaxDeliver)
+		}
+		logger.Warn().Uint64("num_delivered", numDelivered(msg)).Msg("Message exceeded max deliver")
+	}
+	if dlqResult != "" && c.cfg.DeadLetter.Subject != "" {
+		if err := c.deadLetter(ctx, js, msg, dlqResult, reason); err != nil {
+			// 死信发布失败时延迟重新投递，避免消息丢失；超过最大投递次数的消息重新投递后会立即再次转入死信，
+			// 死信主题不可用期间不延迟会反复重新投递
+			delay := c.cfg.retryDelay(numDelivered(msg))
+			if delay <= 0 {
+				delay = c.cfg.RequeueDelay
+			}
+			logger.Error().Err(err).Msg("Failed to publish dead letter, message will be redelivered")
+			result = NakWithDelay(delay)
+		} else {
+			deadLetters.WithLabelValues(c.metricsName(), dlqResult).Inc()
+		}
+	}
+
+	messagesSettled.WithLabelValues(c.metricsName(), strings.ToLower(string(result.Action))).Inc()
+	switch result.Action {
+	case HandleActionAck:
+		if err := msg.Ack(); err != nil {
+			logger.Error().Err(err).Msg("Failed to Ack message")
+		}
+	case HandleActionNak:
+		delay := result.Delay
+		if result.scheduled {
+			delay = c.cfg.retryDelay(numDelivered(msg))
+		}
+		if delay <= 0 {
+			if err := msg.Nak(); err != nil {
+				logger.Error().Err(err).Msg("Failed to Nak message")
+			}
+			return
+		}
+		logger.Info().Dur("delay", delay).Uint64("num_delivered", numDelivered(msg)).Msg("Message will be redelivered after delay")
+		if err := msg.NakWithDelay(delay); err != nil {
+			logger.Error().Err(err).Msg("Failed to Nak message")
+		}
+	case HandleActionTerm:
+		if err := msg.Term(); err != nil {
+			logger.Error().Err(err).Msg("Failed to Term message")
 		}
 	}
 }
@@ -3,62 +3,73 @@ package natsconsumer
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/testharness"
 )
 
-const natsUrl = "nats://192.168.242.2:4222"
-
-func TestNormalConsumer(t *testing.T) {
-	// 构造生产者
-	nc, err := nats.Connect(natsUrl)
-	require.NoError(t, err)
-	defer nc.Drain()
-
-	// 创建js
-	js, err := nc.JetStream()
-	require.NoError(t, err)
-
-	// 确保创建流
-	_ = js.DeleteStream("TEST_STREAM")
-	_, err = js.AddStream(&nats.StreamConfig{
+// setupConsumer 启动 NATS 服务器，创建流 TEST_STREAM 与持久化消费者 TEST_CONSUMER，返回消费者配置
+func setupConsumer(t *testing.T) (*testharness.NatsServer, jetstream.JetStream, *Config) {
+	srv := testharness.StartNatsServer(t)
+	srv.CreateStream(jetstream.StreamConfig{
 		Name:      "TEST_STREAM",
 		Subjects:  []string{"test.*"},
-		Retention: nats.WorkQueuePolicy,
-		Storage:   nats.FileStorage,
+		Retention: jetstream.LimitsPolicy,
+		Storage:   jetstream.FileStorage,
 	})
-	require.NoError(t, err)
-
-	// 创建消费者配置
-	_ = js.DeleteConsumer("TEST_STREAM", "TEST_CONSUMER")
-	_, err = js.AddConsumer("TEST_STREAM", &nats.ConsumerConfig{
-		Durable:   "TEST_CONSUMER",
-		AckPolicy: nats.AckExplicitPolicy,
+	srv.CreateConsumer("TEST_STREAM", jetstream.ConsumerConfig{
+		Durable:       "TEST_CONSUMER",
+		FilterSubject: "test.subject",
 	})
+	return srv, srv.JetStream(), &Config{
+		NatsURL:      srv.URL(),
+		Concurrency:  2,
+		Subject:      "test.subject",
+		ConsumerName: "TEST_CONSUMER",
+		PullMaxWait:  1 * time.Second,
+	}
+}
+
+// runConsumer 在后台运行消费者，返回的函数停止消费者并等待其退出，测试结束时自动停止
+func runConsumer(t *testing.T, cfg *Config, handler HandlerFunc, opts ...Option) (stop func()) {
+	logger := zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.DebugLevel)
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, New(cfg, opts...).Run(ctx, handler))
+	}()
+	stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func publish(t *testing.T, js jetstream.JetStream, subject string, data string) {
+	_, err := js.Publish(context.Background(), subject, []byte(data))
 	require.NoError(t, err)
+}
+
+func TestNormalConsumer(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	fetched := testutil.ToFloat64(messagesFetched.WithLabelValues(cfg.ConsumerName))
+	acked := testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "ack"))
 
 	// 生产10条消息
 	for i := 0; i < 10; i++ {
-		msg := fmt.Sprintf("test message %d", i)
-		_, err = js.Publish("test.subject", []byte(msg))
-		require.NoError(t, err)
+		publish(t, js, "test.subject", fmt.Sprintf("test message %d", i))
 	}
-	ctx := context.Background()
-	logger := zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.DebugLevel)
-	ctx = logger.WithContext(ctx)
-	consumer := New(&Config{
-		NatsURL:      natsUrl,
-		Concurrency:  2,
-		Subject:      "test.subject",
-		ConsumerName: "TEST_CONSUMER",
-		PullMaxWait:  1 * time.Second,
-	})
 
 	ch := make(chan string, 10)
 	var wg sync.WaitGroup
@@ -75,54 +86,26 @@ func TestNormalConsumer(t *testing.T) {
 		return HandleResultAck
 	}
 
-	go consumer.Run(ctx, handler)
+	stop := runConsumer(t, cfg, handler)
 	wg.Wait()
+	stop()
 
 	require.Len(t, ch, 10)
-}
-
-func TestNakConsumer(t *testing.T) {
-	// 构造生产者
-	nc, err := nats.Connect(natsUrl)
+	info, err := js.Consumer(context.Background(), "TEST_STREAM", "TEST_CONSUMER")
 	require.NoError(t, err)
-	defer nc.Drain()
+	require.Zero(t, info.CachedInfo().NumAckPending)
+	require.Zero(t, info.CachedInfo().NumPending)
 
-	// 创建js
-	js, err := nc.JetStream()
-	require.NoError(t, err)
-
-	// 确保创建流
-	_ = js.DeleteStream("TEST_STREAM")
-	_, err = js.AddStream(&nats.StreamConfig{
-		Name:      "TEST_STREAM",
-		Subjects:  []string{"test.*"},
-		Retention: nats.WorkQueuePolicy,
-		Storage:   nats.FileStorage,
-	})
-	require.NoError(t, err)
+	require.Equal(t, fetched+10, testutil.ToFloat64(messagesFetched.WithLabelValues(cfg.ConsumerName)))
+	require.Equal(t, acked+10, testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "ack")))
+	require.NotZero(t, testutil.CollectAndCount(handlerDuration))
+}
 
-	// 创建消费者配置
-	_ = js.DeleteConsumer("TEST_STREAM", "TEST_CONSUMER")
-	_, err = js.AddConsumer("TEST_STREAM", &nats.ConsumerConfig{
-		Durable:   "TEST_CONSUMER",
-		AckPolicy: nats.AckExplicitPolicy,
-	})
-	require.NoError(t, err)
+func TestNakConsumer(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
 
 	// 生产1条消息
-	_, err = js.Publish("test.subject", []byte("test nak message"))
-	require.NoError(t, err)
-
-	ctx := context.Background()
-	logger := zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.DebugLevel)
-	ctx = logger.WithContext(ctx)
-	consumer := New(&Config{
-		NatsURL:      natsUrl,
-		Concurrency:  2,
-		Subject:      "test.subject",
-		ConsumerName: "TEST_CONSUMER",
-		PullMaxWait:  1 * time.Second,
-	})
+	publish(t, js, "test.subject", "test nak message")
 
 	var counter atomic.Int64
 
@@ -156,7 +139,434 @@ func TestNakConsumer(t *testing.T) {
 		}
 	}
 
-	go consumer.Run(ctx, handler)
+	runConsumer(t, cfg, handler)
 	wg.Wait()
 	require.Equal(t, int64(3), counter.Load())
 }
+
+func TestTermConsumer(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	cfg.DeadLetter = DeadLetterConfig{Subject: "test.dead_letters", MaxDeliver: 2}
+	terms := testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "term"))
+	naks := testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "nak"))
+	maxDeliver := testutil.ToFloat64(deadLetters.WithLabelValues(cfg.ConsumerName, deadLetterResultMaxDeliver))
+
+	publish(t, js, "test.subject", "bad message")
+	publish(t, js, "test.subject", "flaky message")
+
+	var handled atomic.Int64
+	runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		handled.Add(1)
+		if string(msg.Data) == "bad message" {
+			SetFailureReason(ctx, "cannot parse")
+			return HandleResultTerm
+		}
+		SetFailureReason(ctx, "model unavailable")
+		return NakWithDelay(10 * time.Millisecond)
+	})
+
+	// 终止的消息立即转入死信，重试失败的消息投递 2 次后转入死信
+	stream, err := js.Stream(context.Background(), "TEST_STREAM")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		info, err := stream.Info(context.Background(), jetstream.WithSubjectFilter("test.dead_letters"))
+		return err == nil && info.State.Subjects["test.dead_letters"] == 2
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, int64(3), handled.Load())
+
+	reasons := map[string]string{}
+	for seq := uint64(1); seq <= 4; seq++ {
+		msg, err := stream.GetMsg(context.Background(), seq)
+		require.NoError(t, err)
+		if msg.Subject != "test.dead_letters" {
+			continue
+		}
+		require.Equal(t, "test.subject", msg.Header.Get(HeaderOriginalSubject))
+		require.Equal(t, "TEST_CONSUMER", msg.Header.Get(HeaderOriginalConsumer))
+		reasons[string(msg.Data)] = msg.Header.Get(HeaderDeadLetterResult) + ": " + msg.Header.Get(HeaderDeadLetterReason)
+	}
+	require.Equal(t, map[string]string{
+		"bad message":   "TERM: cannot parse",
+		"flaky message": "MAX_DELIVER: model unavailable",
+	}, reasons)
+
+	// 重试的消息第一次处理失败时 NAK，两条消息最终都被终止
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "term")) == terms+2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, naks+1, testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "nak")))
+	require.Equal(t, maxDeliver+1, testutil.ToFloat64(deadLetters.WithLabelValues(cfg.ConsumerName, deadLetterResultMaxDeliver)))
+}
+
+func TestDeadLetterPublishFailed(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	// 没有流捕获死信主题，死信发布失败
+	cfg.DeadLetter = DeadLetterConfig{Subject: "no_stream.dead_letters", MaxDeliver: 1}
+	cfg.RetrySchedule = []time.Duration{5 * time.Second}
+	naks := testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "nak"))
+
+	publish(t, js, "test.subject", "flaky message")
+
+	var handled atomic.Int64
+	runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		handled.Add(1)
+		return HandleResultNak
+	})
+
+	// 死信发布失败时延迟重新投递，不会反复立即重新投递
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "nak")) == naks+1
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(2 * time.Second)
+	require.Equal(t, naks+1, testutil.ToFloat64(messagesSettled.WithLabelValues(cfg.ConsumerName, "nak")))
+	require.Equal(t, int64(1), handled.Load())
+
+	// 消息没有丢失，等待重新投递
+	consumer, err := js.Consumer(context.Background(), "TEST_STREAM", "TEST_CONSUMER")
+	require.NoError(t, err)
+	require.Equal(t, 1, consumer.CachedInfo().NumAckPending)
+}
+
+func TestConsumerReconnect(t *testing.T) {
+	srv, js, cfg := setupConsumer(t)
+
+	received := make(chan string, 10)
+	runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		received <- string(msg.Data)
+		return HandleResultAck
+	})
+
+	publish(t, js, "test.subject", "before restart")
+	require.Equal(t, "before restart", waitReceived(t, received))
+
+	// 服务器重启后消费者自动重连并继续接收消息
+	srv.Restart()
+	require.Eventually(t, func() bool {
+		_, err := js.Publish(context.Background(), "test.subject", []byte("after restart"))
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, "after restart", waitReceived(t, received))
+}
+
+func TestConsumerHealth(t *testing.T) {
+	srv, _, cfg := setupConsumer(t)
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+	c := New(cfg)
+	require.False(t, c.Ready())
+	require.Error(t, c.Health())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, c.Run(ctx, func(ctx context.Context, msg *nats.Msg) HandleResult {
+			return HandleResultAck
+		}))
+	}()
+	require.Eventually(t, c.Ready, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, c.Health())
+	st := c.Status(context.Background())
+	require.Equal(t, "CONNECTED", st.Connection)
+	require.True(t, st.Bound)
+	require.Equal(t, "TEST_STREAM", st.Stream)
+	require.Equal(t, cfg.ConsumerName, st.Consumer)
+	require.Empty(t, st.Error)
+
+	// 连接断开期间视为不健康，恢复后重新变为健康
+	srv.Shutdown()
+	require.Eventually(t, func() bool { return c.Health() != nil }, 5*time.Second, 10*time.Millisecond)
+	require.True(t, c.Ready())
+	srv.Restart()
+	require.Eventually(t, func() bool { return c.Health() == nil }, 10*time.Second, 100*time.Millisecond)
+
+	cancel()
+	<-done
+	require.False(t, c.Ready())
+}
+
+func TestConsumerShutdown(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	cfg.Concurrency = 1
+	cfg.BatchSize = 3
+
+	for i := range 3 {
+		publish(t, js, "test.subject", fmt.Sprintf("message %d", i))
+	}
+
+	// 停止时正在处理的消息不受影响，处理完成后确认，尚未开始处理的消息立即重新投递
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled []string
+	var handleErr error
+	stop := runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		handled = append(handled, string(msg.Data))
+		close(started)
+		<-release
+		handleErr = ctx.Err()
+		return HandleResultAck
+	})
+	<-started
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("consumer stopped before the in-flight message finished")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	require.Equal(t, []string{"message 0"}, handled)
+	require.NoError(t, handleErr)
+
+	received := make(chan string, 10)
+	runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		received <- string(msg.Data)
+		return HandleResultAck
+	})
+	require.ElementsMatch(t, []string{"message 1", "message 2"}, []string{waitReceived(t, received), waitReceived(t, received)})
+}
+
+func TestConsumerShutdownTimeout(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	cfg.ShutdownTimeout = 100 * time.Millisecond
+	publish(t, js, "test.subject", "slow message")
+
+	// 超过 shutdown_timeout 仍未完成的处理被取消
+	started := make(chan struct{})
+	stop := runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		close(started)
+		<-ctx.Done()
+		return HandleResultNak
+	})
+	<-started
+	begin := time.Now()
+	stop()
+	require.Less(t, time.Since(begin), 5*time.Second)
+}
+
+func TestConsumerInProgress(t *testing.T) {
+	srv, js, cfg := setupConsumer(t)
+	srv.CreateConsumer("TEST_STREAM", jetstream.ConsumerConfig{
+		Durable:       "SLOW_CONSUMER",
+		FilterSubject: "test.slow",
+		AckWait:       500 * time.Millisecond,
+	})
+	cfg.Subject = "test.slow"
+	cfg.ConsumerName = "SLOW_CONSUMER"
+	cfg.InProgressInterval = 100 * time.Millisecond
+	publish(t, js, "test.slow", "slow message")
+
+	// 处理耗时超过 AckWait，期间定期发送 InProgress，消息不会被重复投递
+	var deliveries atomic.Int32
+	done := make(chan struct{})
+	stop := runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		if deliveries.Add(1) == 1 {
+			time.Sleep(1500 * time.Millisecond)
+			close(done)
+		}
+		return HandleResultAck
+	})
+	<-done
+	// 等待超过 AckWait，确认没有重新投递
+	time.Sleep(time.Second)
+	stop()
+	require.Equal(t, int32(1), deliveries.Load())
+
+	cons, err := js.Consumer(context.Background(), "TEST_STREAM", "SLOW_CONSUMER")
+	require.NoError(t, err)
+	info, err := cons.Info(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, info.NumRedelivered)
+	require.Zero(t, info.NumAckPending)
+}
+
+func TestOrderedConsumer(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	for i := range 5 {
+		publish(t, js, "test.subject", fmt.Sprintf("message %d", i))
+	}
+
+	// 有序消费者从指定序号开始重放，不影响持久化消费者
+	cfg.Mode = ConsumerModeOrdered
+	cfg.ConsumerName = ""
+	cfg.Concurrency = 1
+	cfg.Ordered.StartSequence = 3
+	received := make(chan string, 10)
+	runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		received <- string(msg.Data)
+		return HandleResultNak
+	})
+	for i := 2; i < 5; i++ {
+		require.Equal(t, fmt.Sprintf("message %d", i), waitReceived(t, received))
+	}
+
+	consumer, err := js.Consumer(context.Background(), "TEST_STREAM", "TEST_CONSUMER")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), consumer.CachedInfo().NumPending)
+}
+
+func TestConsumerOrderingKeyBacklog(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	cfg.Concurrency = 2
+	cfg.BatchSize = 2
+
+	// 会话 a 积压了多条消息，之后到达的会话 b 的消息不需要等待会话 a 处理完
+	for i := range 5 {
+		publish(t, js, "test.subject", fmt.Sprintf("a-%d", i))
+	}
+	publish(t, js, "test.subject", "b-0")
+
+	release := make(chan struct{})
+	received := make(chan string, 10)
+	runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		if strings.HasPrefix(string(msg.Data), "a-") {
+			<-release
+		}
+		received <- string(msg.Data)
+		return HandleResultAck
+	}, WithOrderingKey(func(msg *nats.Msg) string {
+		key, _, _ := strings.Cut(string(msg.Data), "-")
+		return key
+	}))
+
+	require.Equal(t, "b-0", waitReceived(t, received))
+	close(release)
+	for i := range 5 {
+		require.Equal(t, fmt.Sprintf("a-%d", i), waitReceived(t, received))
+	}
+}
+
+func TestConsumerOrderingKeyQueueFull(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	cfg.Concurrency = 2
+	cfg.BatchSize = 2
+	cfg.MaxPendingPerKey = 1
+	cfg.RequeueDelay = 100 * time.Millisecond
+
+	// 会话 a 的排队已满，之后到达的会话 a 的消息稍后重新投递，不阻塞会话 b 的消息
+	for i := range 4 {
+		publish(t, js, "test.subject", fmt.Sprintf("a-%d", i))
+	}
+	publish(t, js, "test.subject", "b-0")
+
+	release := make(chan struct{})
+	received := make(chan string, 10)
+	runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		if strings.HasPrefix(string(msg.Data), "a-") {
+			<-release
+		}
+		received <- string(msg.Data)
+		return HandleResultAck
+	}, WithOrderingKey(func(msg *nats.Msg) string {
+		key, _, _ := strings.Cut(string(msg.Data), "-")
+		return key
+	}))
+
+	require.Equal(t, "b-0", waitReceived(t, received))
+	close(release)
+	var got []string
+	for range 4 {
+		got = append(got, waitReceived(t, received))
+	}
+	require.ElementsMatch(t, []string{"a-0", "a-1", "a-2", "a-3"}, got)
+}
+
+func TestConsumerMaxQueueWait(t *testing.T) {
+	_, js, cfg := setupConsumer(t)
+	cfg.Concurrency = 1
+	cfg.BatchSize = 2
+	cfg.InProgressInterval = 50 * time.Millisecond
+	cfg.MaxQueueWait = 200 * time.Millisecond
+	cfg.RequeueDelay = 100 * time.Millisecond
+	publish(t, js, "test.subject", "a-0")
+	publish(t, js, "test.subject", "a-1")
+
+	// a-0 长时间处理不完，排队的 a-1 超过 max_queue_wait 后重新投递，处理完 a-0 后仍会处理 a-1
+	release := make(chan struct{})
+	received := make(chan string, 10)
+	var deliveries atomic.Int32
+	runConsumer(t, cfg, func(ctx context.Context, msg *nats.Msg) HandleResult {
+		if string(msg.Data) == "a-0" {
+			<-release
+		} else {
+			meta, err := msg.Metadata()
+			require.NoError(t, err)
+			deliveries.Store(int32(meta.NumDelivered))
+		}
+		received <- string(msg.Data)
+		return HandleResultAck
+	}, WithOrderingKey(func(msg *nats.Msg) string {
+		key, _, _ := strings.Cut(string(msg.Data), "-")
+		return key
+	}))
+
+	time.Sleep(time.Second)
+	close(release)
+	require.Equal(t, "a-0", waitReceived(t, received))
+	require.Equal(t, "a-1", waitReceived(t, received))
+	require.Greater(t, deliveries.Load(), int32(1))
+}
+
+func waitReceived(t *testing.T, ch <-chan string) string {
+	select {
+	case s := <-ch:
+		return s
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for message")
+		return ""
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	cfg := &Config{NatsURL: "nats://127.0.0.1:4222", Subject: "test.subject", ConsumerName: "TEST_CONSUMER"}
+	require.NoError(t, cfg.Validate())
+
+	// 按投递次数依次使用重试计划中的延迟，超出部分使用最后一项
+	require.Equal(t, 1*time.Second, cfg.retryDelay(0))
+	require.Equal(t, 1*time.Second, cfg.retryDelay(1))
+	require.Equal(t, 5*time.Second, cfg.retryDelay(2))
+	require.Equal(t, 2*time.Minute, cfg.retryDelay(4))
+	require.Equal(t, 2*time.Minute, cfg.retryDelay(100))
+}
+
+func TestOrderedConfig(t *testing.T) {
+	cfg := &Config{NatsURL: "nats://127.0.0.1:4222", Subject: "test.subject", Mode: ConsumerModeOrdered}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, jetstream.DeliverAllPolicy, cfg.Ordered.consumerConfig(cfg.Subject).DeliverPolicy)
+
+	// 指定起始序号时从该序号开始重放
+	cfg.Ordered.StartSequence = 42
+	oc := cfg.Ordered.consumerConfig(cfg.Subject)
+	require.Equal(t, jetstream.DeliverByStartSequencePolicy, oc.DeliverPolicy)
+	require.Equal(t, uint64(42), oc.OptStartSeq)
+	require.Equal(t, []string{"test.subject"}, oc.FilterSubjects)
+
+	cfg.Ordered.DeliverPolicy = "first"
+	require.Error(t, cfg.Validate())
+
+	// 持久化模式必须指定消费者名称
+	require.Error(t, (&Config{NatsURL: "nats://127.0.0.1:4222", Subject: "test.subject"}).Validate())
+}
+
+func TestToNatsMsg(t *testing.T) {
+	// 转换后的消息可以读取元数据，但不能绕过消费者直接确认
+	msg := toNatsMsg(&fakeMsg{reply: "$JS.ACK.TEST_STREAM.TEST_CONSUMER.3.7.5.1700000000000000000.0"})
+	meta, err := msg.Metadata()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), meta.NumDelivered)
+	require.Equal(t, uint64(7), meta.Sequence.Stream)
+	require.Error(t, msg.Ack())
+}
+
+type fakeMsg struct {
+	jetstream.Msg
+	reply string
+}
+
+func (m *fakeMsg) Subject() string      { return "test.subject" }
+func (m *fakeMsg) Reply() string        { return m.reply }
+func (m *fakeMsg) Headers() nats.Header { return nats.Header{} }
+func (m *fakeMsg) Data() []byte         { return nil }
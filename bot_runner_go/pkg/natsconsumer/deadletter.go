@@ -0,0 +1,89 @@
+package natsconsumer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+)
+
+// 死信消息附加的消息头，原消息的消息头会一并保留
+const (
+	HeaderDeadLetterReason    = "Dlq-Reason"             // 进入死信的原因
+	HeaderDeadLetterResult    = "Dlq-Result"             // 触发死信的处理结果：TERM 或 MAX_DELIVER
+	HeaderOriginalSubject     = "Dlq-Original-Subject"   // 原消息主题
+	HeaderOriginalStream      = "Dlq-Original-Stream"    // 原消息所在的流
+	HeaderOriginalConsumer    = "Dlq-Original-Consumer"  // 原消息的消费者
+	HeaderOriginalSequence    = "Dlq-Original-Sequence"  // 原消息在流中的序号
+	HeaderOriginalTimestamp   = "Dlq-Original-Timestamp" // 原消息写入流的时间，RFC3339Nano 格式
+	HeaderOriginalNumDelivery = "Dlq-Num-Delivered"      // 原消息的投递次数
+)
+
+const deadLetterResultMaxDeliver = "MAX_DELIVER"
+
+type failureReason struct {
+	reason string
+}
+
+type ctxKeyFailureReason struct{}
+
+// SetFailureReason 记录消息处理失败的原因，消息进入死信时写入 Dlq-Reason 消息头
+func SetFailureReason(ctx context.Context, reason string) {
+	if v, ok := ctx.Value(ctxKeyFailureReason{}).(*failureReason); ok {
+		v.reason = reason
+	}
+}
+
+// deadLetter 把消息连同原消息头与元数据发布到死信主题
+func (c *Consumer) deadLetter(ctx context.Context, js jetstream.JetStream, msg jetstream.Msg, result, reason string) error {
+	if c.cfg.DeadLetter.Subject == "" {
+		return errors.New("dead letter subject is not configured")
+	}
+
+	dlq := nats.NewMsg(c.cfg.DeadLetter.Subject)
+	dlq.Data = msg.Data()
+	for key, values := range msg.Headers() {
+		// 保留原消息去重 ID 会导致死信被当作重复消息丢弃
+		if key == nats.MsgIdHdr {
+			continue
+		}
+		dlq.Header[key] = append([]string(nil), values...)
+	}
+	dlq.Header.Set(HeaderDeadLetterResult, result)
+	dlq.Header.Set(HeaderDeadLetterReason, reason)
+	dlq.Header.Set(HeaderOriginalSubject, msg.Subject())
+	if meta, err := msg.Metadata(); err == nil {
+		dlq.Header.Set(HeaderOriginalStream, meta.Stream)
+		dlq.Header.Set(HeaderOriginalConsumer, meta.Consumer)
+		dlq.Header.Set(HeaderOriginalSequence, strconv.FormatUint(meta.Sequence.Stream, 10))
+		dlq.Header.Set(HeaderOriginalTimestamp, meta.Timestamp.Format(time.RFC3339Nano))
+		dlq.Header.Set(HeaderOriginalNumDelivery, strconv.FormatUint(meta.NumDelivered, 10))
+		// 同一条原消息只会进入一次死信
+		dlq.Header.Set(nats.MsgIdHdr, "dlq-"+meta.Stream+"-"+strconv.FormatUint(meta.Sequence.Stream, 10))
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := js.PublishMsg(pubCtx, dlq); err != nil {
+		return err
+	}
+	zerolog.Ctx(ctx).Warn().
+		Str("dead_letter_subject", dlq.Subject).
+		Str("result", result).
+		Str("reason", reason).
+		Msg("Message moved to dead letter subject")
+	return nil
+}
+
+// numDelivered 返回消息的投递次数，无法获取元数据时返回 0
+func numDelivered(msg jetstream.Msg) uint64 {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return 0
+	}
+	return meta.NumDelivered
+}
@@ -0,0 +1,49 @@
+package natsconsumer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// messagesFetched 从服务器接收的消息数
+	messagesFetched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "consumer",
+		Name:      "messages_fetched_total",
+		Help:      "Number of messages fetched from JetStream.",
+	}, []string{"consumer"})
+
+	// messagesSettled 确认的消息数，action 为 ack/nak/term，停止时放弃处理的消息计为 nak
+	messagesSettled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "consumer",
+		Name:      "messages_settled_total",
+		Help:      "Number of messages acknowledged, by action (ack, nak, term).",
+	}, []string{"consumer", "action"})
+
+	// deadLetters 转入死信主题的消息数，result 为 TERM 或 MAX_DELIVER
+	deadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "consumer",
+		Name:      "dead_letters_total",
+		Help:      "Number of messages published to the dead letter subject, by result.",
+	}, []string{"consumer", "result"})
+
+	// handlerDuration 处理函数的耗时，按工作协程区分
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "consumer",
+		Name:      "handler_duration_seconds",
+		Help:      "Time spent in the message handler, by worker.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"consumer", "worker"})
+)
+
+// metricsName 指标中的消费者名称，有序消费者没有固定名称，使用订阅的主题
+func (c *Consumer) metricsName() string {
+	if c.ordered() {
+		return c.cfg.Subject
+	}
+	return c.cfg.ConsumerName
+}
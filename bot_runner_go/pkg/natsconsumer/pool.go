@@ -0,0 +1,153 @@
+package natsconsumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// OrderingKeyFunc 返回消息的顺序键，顺序键相同的消息按接收顺序依次处理，不同顺序键的消息并行处理，
+// 返回空字符串的消息不保证顺序
+type OrderingKeyFunc func(msg *nats.Msg) string
+
+// job 一条已拉取、等待处理的消息
+type job struct {
+	key     string        // 顺序键
+	msg     jetstream.Msg // 消息，用于确认
+	natsMsg *nats.Msg     // 传给处理函数的消息
+	stop    func()        // 停止发送 InProgress
+	queued  time.Time     // 开始排队等待的时间
+}
+
+// errKeyQueueFull 顺序键排队的任务数已达上限
+var errKeyQueueFull = errors.New("ordering key queue is full")
+
+// orderedQueue 把任务分发给工作协程，同一顺序键同一时间只有一个任务在处理，其余任务按提交顺序排队，
+// 排队的任务不占用处理名额，某个顺序键的消息积压时其他顺序键的消息仍可处理
+type orderedQueue struct {
+	jobs       chan *job // 可以立即处理的任务
+	maxPending int       // 每个顺序键排队等待的任务数上限
+
+	mu      sync.Mutex
+	pending map[string][]*job // 顺序键正在处理时排队等待的任务
+	changed chan struct{}     // 有排队的任务开始处理时关闭并替换，用于唤醒等待排队的提交者
+}
+
+// newOrderedQueue 创建任务队列，capacity 为可以立即处理的任务数上限，maxPending 为每个顺序键排队等待的任务数上限
+func newOrderedQueue(capacity int, maxPending int) *orderedQueue {
+	return &orderedQueue{
+		jobs:       make(chan *job, capacity),
+		maxPending: maxPending,
+		pending:    make(map[string][]*job),
+		changed:    make(chan struct{}),
+	}
+}
+
+// Submit 提交任务，同一顺序键已有任务在处理时排队等待，返回 true 表示任务在排队，不占用处理名额。
+// 该顺序键排队的任务数已达上限时不会阻塞，返回 errKeyQueueFull，由调用方决定如何处理该任务
+func (q *orderedQueue) Submit(j *job) (queued bool, err error) {
+	if j.key == "" {
+		q.jobs <- j
+		return false, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiting, ok := q.pending[j.key]
+	if !ok {
+		q.pending[j.key] = nil
+		q.jobs <- j
+		return false, nil
+	}
+	if len(waiting) >= q.maxPending {
+		return false, errKeyQueueFull
+	}
+	j.queued = time.Now()
+	q.pending[j.key] = append(waiting, j)
+	return true, nil
+}
+
+// Wait 阻塞直到顺序键有排队的空位或 ctx 取消，ctx 取消时返回 ctx 的错误
+func (q *orderedQueue) Wait(ctx context.Context, key string) error {
+	for {
+		q.mu.Lock()
+		full := len(q.pending[key]) >= q.maxPending
+		changed := q.changed
+		q.mu.Unlock()
+		if !full {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Expire 取出排队最久的任务在 before 之前开始排队的顺序键的全部排队任务，
+// 这些顺序键正在处理的任务长时间没有完成，其后的任务不再继续等待
+func (q *orderedQueue) Expire(before time.Time) []*job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ret []*job
+	for key, waiting := range q.pending {
+		if len(waiting) > 0 && waiting[0].queued.Before(before) {
+			ret = append(ret, waiting...)
+			q.pending[key] = nil
+		}
+	}
+	if len(ret) > 0 {
+		close(q.changed)
+		q.changed = make(chan struct{})
+	}
+	return ret
+}
+
+// Done 任务处理完成，放行同一顺序键的下一个任务，返回 true 表示已放行，完成的任务的处理名额转交给放行的任务
+func (q *orderedQueue) Done(j *job) bool {
+	if j.key == "" {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiting := q.pending[j.key]
+	if len(waiting) == 0 {
+		delete(q.pending, j.key)
+		return false
+	}
+	q.pending[j.key] = waiting[1:]
+	q.jobs <- waiting[0]
+	close(q.changed)
+	q.changed = make(chan struct{})
+	return true
+}
+
+// Drain 取出所有尚未开始处理的任务
+func (q *orderedQueue) Drain() []*job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ret []*job
+	for {
+		select {
+		case j := <-q.jobs:
+			ret = append(ret, j)
+			continue
+		default:
+		}
+		break
+	}
+	for _, waiting := range q.pending {
+		ret = append(ret, waiting...)
+	}
+	clear(q.pending)
+	return ret
+}
@@ -0,0 +1,133 @@
+package natsconsumer
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestJob(key string, seq int) *job {
+	return &job{key: key, natsMsg: &nats.Msg{Data: []byte(strconv.Itoa(seq))}, stop: func() {}}
+}
+
+func TestOrderedQueue(t *testing.T) {
+	q := newOrderedQueue(100, 100)
+
+	var mu sync.Mutex
+	running := map[string]bool{}
+	handled := map[string][]string{}
+	overlapped := false
+	var wg sync.WaitGroup
+	for range 4 {
+		go func() {
+			for j := range q.jobs {
+				mu.Lock()
+				overlapped = overlapped || running[j.key]
+				running[j.key] = true
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				running[j.key] = false
+				handled[j.key] = append(handled[j.key], string(j.natsMsg.Data))
+				mu.Unlock()
+				q.Done(j)
+				wg.Done()
+			}
+		}()
+	}
+
+	var want []string
+	for i := range 10 {
+		want = append(want, strconv.Itoa(i))
+		for _, key := range []string{"a", "b", "c"} {
+			wg.Add(1)
+			_, err := q.Submit(newTestJob(key, i))
+			require.NoError(t, err)
+		}
+	}
+	wg.Wait()
+	close(q.jobs)
+
+	// 同一顺序键的任务不会同时处理，并且按提交顺序处理
+	require.False(t, overlapped)
+	for _, key := range []string{"a", "b", "c"} {
+		require.Equal(t, want, handled[key])
+	}
+}
+
+func TestOrderedQueueDrain(t *testing.T) {
+	q := newOrderedQueue(10, 10)
+	for _, j := range []*job{newTestJob("a", 0), newTestJob("a", 1), newTestJob("", 2)} {
+		_, err := q.Submit(j)
+		require.NoError(t, err)
+	}
+
+	// 顺序键 a 的第二个任务在排队，其余两个任务可以立即处理
+	require.Len(t, q.jobs, 2)
+	require.Len(t, q.Drain(), 3)
+	require.Empty(t, q.Drain())
+}
+
+func TestOrderedQueueMaxPending(t *testing.T) {
+	q := newOrderedQueue(10, 1)
+	ctx := context.Background()
+	a0, a1, a2 := newTestJob("a", 0), newTestJob("a", 1), newTestJob("a", 2)
+
+	// 第一个任务立即处理，第二个任务排队，不占用处理名额
+	queued, err := q.Submit(a0)
+	require.NoError(t, err)
+	require.False(t, queued)
+	queued, err = q.Submit(a1)
+	require.NoError(t, err)
+	require.True(t, queued)
+
+	// 排队数达到上限时不阻塞，其他顺序键不受影响
+	_, err = q.Submit(a2)
+	require.ErrorIs(t, err, errKeyQueueFull)
+	queued, err = q.Submit(newTestJob("b", 0))
+	require.NoError(t, err)
+	require.False(t, queued)
+
+	// 等待空位直到 ctx 取消
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, q.Wait(timeoutCtx, "a"), context.DeadlineExceeded)
+
+	// 前一个任务完成后放行排队的任务，等待随之结束
+	waited := make(chan error)
+	go func() {
+		waited <- q.Wait(ctx, "a")
+	}()
+	require.Same(t, a0, <-q.jobs)
+	require.True(t, q.Done(a0))
+	require.NoError(t, <-waited)
+	queued, err = q.Submit(a2)
+	require.NoError(t, err)
+	require.True(t, queued)
+}
+
+func TestOrderedQueueExpire(t *testing.T) {
+	q := newOrderedQueue(10, 10)
+	a0, a1, a2, b0 := newTestJob("a", 0), newTestJob("a", 1), newTestJob("a", 2), newTestJob("b", 0)
+	for _, j := range []*job{a0, a1, a2, b0} {
+		_, err := q.Submit(j)
+		require.NoError(t, err)
+	}
+
+	// 尚未超时的排队任务保留
+	require.Empty(t, q.Expire(time.Now().Add(-time.Minute)))
+
+	// 排队超时的顺序键取出全部排队任务，正在处理的任务完成后不再放行
+	require.Equal(t, []*job{a1, a2}, q.Expire(time.Now().Add(time.Second)))
+	require.False(t, q.Done(a0))
+	queued, err := q.Submit(newTestJob("a", 3))
+	require.NoError(t, err)
+	require.False(t, queued)
+}
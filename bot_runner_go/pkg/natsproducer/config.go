@@ -2,61 +2,58 @@ package natsproducer
 
 import (
 	"errors"
+	"fmt"
+	"time"
+)
+
+type PublishMode string
 
-	"github.com/nats-io/nats.go"
+const (
+	PublishModeCore      PublishMode = "core"      // 核心 NATS 发布，不确认消息是否被存储
+	PublishModeJetStream PublishMode = "jetstream" // JetStream 发布，等待 PubAck 确认消息已被存储
 )
 
 type Config struct {
-	NatsURL string `yaml:"nats_url"` // NATS 服务器地址
-	Subject string `yaml:"subject"`  // 发布主题
+	NatsURL string      `yaml:"nats_url"` // NATS 服务器地址
+	Subject string      `yaml:"subject"`  // 发布主题
+	Mode    PublishMode `yaml:"mode"`     // 发布方式：core/jetstream，默认 core
+
+	BotAccount string `yaml:"bot_account"` // 写入消息信封的机器人账号，回复消息沿用所回复消息的账号
+
+	// 以下配置仅在 jetstream 模式下生效
+	AckTimeout time.Duration `yaml:"ack_timeout"` // 等待 PubAck 的超时时间，默认 5s
+	MaxRetries *int          `yaml:"max_retries"` // 超时或流暂不可用时的最大重试次数，不配置时默认 3，0 表示不重试
+	RetryWait  time.Duration `yaml:"retry_wait"`  // 重试间隔，默认 500ms
 }
 
 func (c *Config) Validate() error {
-	if c.NatsURL == "" {
-		return errors.New("nats_url is required")
+	if c.Mode == "" {
+		c.Mode = PublishModeCore
 	}
-	if c.Subject == "" {
-		return errors.New("subject is required")
+	if c.AckTimeout <= 0 {
+		c.AckTimeout = 5 * time.Second
 	}
-	return nil
-}
-
-type Producer struct {
-	subject string     // 发布主题
-	nc      *nats.Conn // NATS 连接
-}
-
-func New(cfg *Config) (*Producer, error) {
-	if err := cfg.Validate(); err != nil {
-		return nil, err
+	if c.MaxRetries == nil {
+		maxRetries := 3
+		c.MaxRetries = &maxRetries
+	}
+	if c.RetryWait <= 0 {
+		c.RetryWait = 500 * time.Millisecond
 	}
 
-	nc, err := nats.Connect(cfg.NatsURL)
-	if err != nil {
-		return nil, err
+	if c.NatsURL == "" {
+		return errors.New("nats_url is required")
 	}
-	producer := &Producer{
-		subject: cfg.Subject,
-		nc:      nc,
+	if c.Subject == "" {
+		return errors.New("subject is required")
 	}
-	return producer, nil
-}
-
-func (p *Producer) Publish(msg []byte) error {
-	if p.nc == nil {
-		return errors.New("NATS connection is not initialized")
+	switch c.Mode {
+	case PublishModeCore, PublishModeJetStream:
+	default:
+		return fmt.Errorf("unknown publish mode: %s", c.Mode)
 	}
-
-	err := p.nc.Publish(p.subject, msg)
-	if err != nil {
-		return err
+	if *c.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
 	}
 	return nil
 }
-
-func (p *Producer) Close() {
-	if p.nc != nil {
-		p.nc.Close()
-		p.nc = nil
-	}
-}
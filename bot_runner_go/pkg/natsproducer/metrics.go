@@ -0,0 +1,32 @@
+package natsproducer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// messagesPublished 发布成功的消息数，jetstream 模式下为收到 PubAck 的消息数
+	messagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "producer",
+		Name:      "messages_published_total",
+		Help:      "Number of messages published successfully.",
+	}, []string{"subject"})
+
+	// publishFailures 重试后仍发布失败的消息数
+	publishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "producer",
+		Name:      "publish_failures_total",
+		Help:      "Number of messages that failed to publish after retries.",
+	}, []string{"subject"})
+
+	// publishRetries jetstream 模式下重试发布的次数
+	publishRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "producer",
+		Name:      "publish_retries_total",
+		Help:      "Number of JetStream publish retries.",
+	}, []string{"subject"})
+)
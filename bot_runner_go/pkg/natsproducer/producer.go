@@ -0,0 +1,188 @@
+package natsproducer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/envelope"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsconn"
+)
+
+type publishOptions struct {
+	msgID    string
+	envelope *envelope.Envelope
+}
+
+type PublishOption func(*publishOptions)
+
+// WithMsgID 设置 Nats-Msg-Id 消息头，JetStream 会在去重窗口内丢弃 ID 相同的消息
+func WithMsgID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.msgID = id
+	}
+}
+
+// WithEnvelope 指定消息信封，未指定时若 context 中有正在处理的消息则生成其回复的信封，否则生成新的信封
+func WithEnvelope(e *envelope.Envelope) PublishOption {
+	return func(o *publishOptions) {
+		o.envelope = e
+	}
+}
+
+type Producer struct {
+	cfg *Config                   // 配置
+	nc  atomic.Pointer[nats.Conn] // NATS 连接，Close 后为 nil
+	js  jetstream.JetStream       // JetStream 客户端，仅 jetstream 模式下存在
+}
+
+func New(cfg *Config) (*Producer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	nc, err := natsconn.Connect(cfg.NatsURL)
+	if err != nil {
+		return nil, err
+	}
+	producer := &Producer{cfg: cfg}
+	producer.nc.Store(nc)
+	if cfg.Mode == PublishModeJetStream {
+		if producer.js, err = jetstream.New(nc); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return producer, nil
+}
+
+// Health 检查 NATS 连接状态
+func (p *Producer) Health() error {
+	nc := p.nc.Load()
+	if nc == nil {
+		return errors.New("NATS connection is not initialized")
+	}
+	if status := nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection is %s", status)
+	}
+	return nil
+}
+
+// Status 生产者的运行状态
+type Status struct {
+	Connection string      `json:"connection"` // NATS 连接状态
+	Subject    string      `json:"subject"`    // 发布的主题
+	Mode       PublishMode `json:"mode"`       // 发布模式
+}
+
+func (p *Producer) Status() Status {
+	st := Status{Connection: "NOT_CONNECTED", Subject: p.cfg.Subject, Mode: p.cfg.Mode}
+	if nc := p.nc.Load(); nc != nil {
+		st.Connection = nc.Status().String()
+	}
+	return st
+}
+
+// Publish 发布消息，jetstream 模式下等待 PubAck 确认消息已被存储，超时或流暂不可用时按配置重试
+func (p *Producer) Publish(ctx context.Context, data []byte, opts ...PublishOption) error {
+	nc := p.nc.Load()
+	if nc == nil {
+		return errors.New("NATS connection is not initialized")
+	}
+	var o publishOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	msg := nats.NewMsg(p.cfg.Subject)
+	msg.Data = data
+	if o.msgID != "" {
+		msg.Header.Set(nats.MsgIdHdr, o.msgID)
+	}
+	p.envelope(ctx, &o).Write(msg.Header)
+	var err error
+	if p.js == nil {
+		err = nc.PublishMsg(msg)
+	} else {
+		err = p.publishJetStream(ctx, msg)
+	}
+	if err != nil {
+		publishFailures.WithLabelValues(p.cfg.Subject).Inc()
+		return err
+	}
+	messagesPublished.WithLabelValues(p.cfg.Subject).Inc()
+	return nil
+}
+
+func (p *Producer) envelope(ctx context.Context, o *publishOptions) *envelope.Envelope {
+	if o.envelope != nil {
+		return o.envelope
+	}
+	if in, ok := envelope.FromContext(ctx); ok {
+		e := in.Reply()
+		if e.BotAccount == "" {
+			e.BotAccount = p.cfg.BotAccount
+		}
+		return e
+	}
+	return envelope.New(p.cfg.BotAccount)
+}
+
+func (p *Producer) publishJetStream(ctx context.Context, msg *nats.Msg) error {
+	logger := zerolog.Ctx(ctx).With().
+		Str("subject", msg.Subject).
+		Str("msg_id", msg.Header.Get(nats.MsgIdHdr)).
+		Str("trace_id", msg.Header.Get(envelope.HeaderTraceID)).
+		Logger()
+
+	var err error
+	for attempt := 0; attempt <= *p.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			publishRetries.WithLabelValues(p.cfg.Subject).Inc()
+			logger.Warn().Err(err).Int("attempt", attempt).Msg("Retrying JetStream publish")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(p.cfg.RetryWait):
+			}
+		}
+
+		var ack *jetstream.PubAck
+		ack, err = p.publishOnce(ctx, msg)
+		if err == nil {
+			if ack.Duplicate {
+				logger.Info().Str("stream", ack.Stream).Uint64("seq", ack.Sequence).Msg("Duplicate message ignored by JetStream")
+			}
+			return nil
+		}
+		if ctx.Err() != nil || !isRetryableError(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to publish to JetStream after %d retries: %w", *p.cfg.MaxRetries, err)
+}
+
+func (p *Producer) publishOnce(ctx context.Context, msg *nats.Msg) (*jetstream.PubAck, error) {
+	ackCtx, cancel := context.WithTimeout(ctx, p.cfg.AckTimeout)
+	defer cancel()
+	return p.js.PublishMsg(ackCtx, msg)
+}
+
+// isRetryableError 等待 PubAck 超时或流暂时没有响应时可以重试，重试时 Nats-Msg-Id 不变，不会产生重复消息
+func isRetryableError(err error) bool {
+	return errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, jetstream.ErrNoStreamResponse) ||
+		errors.Is(err, nats.ErrNoResponders)
+}
+
+func (p *Producer) Close() {
+	if nc := p.nc.Swap(nil); nc != nil {
+		nc.Close()
+	}
+}
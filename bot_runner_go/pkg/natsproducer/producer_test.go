@@ -0,0 +1,121 @@
+package natsproducer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/envelope"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/testharness"
+)
+
+func newTestProducer(t *testing.T, cfg *Config) *Producer {
+	p, err := New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestPublishCore(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	nc, err := nats.Connect(srv.URL())
+	require.NoError(t, err)
+	defer nc.Close()
+	sub, err := nc.SubscribeSync("test.subject")
+	require.NoError(t, err)
+
+	p := newTestProducer(t, &Config{NatsURL: srv.URL(), Subject: "test.subject", BotAccount: "bot"})
+	published := testutil.ToFloat64(messagesPublished.WithLabelValues("test.subject"))
+	require.NoError(t, p.Publish(context.Background(), []byte("hello")))
+	require.Equal(t, published+1, testutil.ToFloat64(messagesPublished.WithLabelValues("test.subject")))
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(msg.Data))
+	env, err := envelope.Read(msg.Header)
+	require.NoError(t, err)
+	require.Equal(t, "bot", env.BotAccount)
+	require.Equal(t, envelope.ContentTypeJSON, env.ContentType)
+}
+
+func TestPublishJetStreamDeduplicates(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	stream := srv.CreateStream(jetstream.StreamConfig{Name: "TEST_STREAM", Subjects: []string{"test.*"}})
+
+	p := newTestProducer(t, &Config{NatsURL: srv.URL(), Subject: "test.subject", Mode: PublishModeJetStream})
+	ctx := context.Background()
+
+	// 相同 Nats-Msg-Id 的消息只存储一次，回复沿用所回复消息的追踪 ID
+	in := envelope.New("bot")
+	ctx = envelope.WithContext(ctx, in)
+	require.NoError(t, p.Publish(ctx, []byte("reply"), WithMsgID("reply-1-0")))
+	require.NoError(t, p.Publish(ctx, []byte("reply"), WithMsgID("reply-1-0")))
+	require.NoError(t, p.Publish(ctx, []byte("another"), WithMsgID("reply-1-1")))
+
+	info, err := stream.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), info.State.Msgs)
+
+	msg, err := stream.GetMsg(ctx, 1)
+	require.NoError(t, err)
+	env, err := envelope.Read(msg.Header)
+	require.NoError(t, err)
+	require.Equal(t, in.TraceID, env.TraceID)
+	require.Equal(t, in.MessageID, env.InReplyTo)
+}
+
+func TestPublishJetStreamNoStream(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+
+	// 没有流捕获主题时按配置重试后返回错误，max_retries 为 0 时不重试
+	for _, maxRetries := range []int{2, 0} {
+		p := newTestProducer(t, &Config{
+			NatsURL:    srv.URL(),
+			Subject:    "test.subject",
+			Mode:       PublishModeJetStream,
+			MaxRetries: &maxRetries,
+			RetryWait:  10 * time.Millisecond,
+		})
+		failures := testutil.ToFloat64(publishFailures.WithLabelValues("test.subject"))
+		retries := testutil.ToFloat64(publishRetries.WithLabelValues("test.subject"))
+		err := p.Publish(context.Background(), []byte("hello"))
+		require.ErrorIs(t, err, jetstream.ErrNoStreamResponse)
+		require.Equal(t, failures+1, testutil.ToFloat64(publishFailures.WithLabelValues("test.subject")))
+		require.Equal(t, retries+float64(maxRetries), testutil.ToFloat64(publishRetries.WithLabelValues("test.subject")))
+	}
+}
+
+func TestConfigMaxRetries(t *testing.T) {
+	cfg := &Config{NatsURL: "nats://127.0.0.1:4222", Subject: "test.subject"}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, 3, *cfg.MaxRetries)
+
+	negative := -1
+	cfg.MaxRetries = &negative
+	require.Error(t, cfg.Validate())
+}
+
+func TestProducerClose(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	p := newTestProducer(t, &Config{NatsURL: srv.URL(), Subject: "test.subject"})
+	require.NoError(t, p.Health())
+
+	// 关闭与状态检查并发执行，关闭后不能再发布消息
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			_ = p.Health()
+			_ = p.Status()
+		}
+	}()
+	p.Close()
+	<-done
+	require.Error(t, p.Health())
+	require.Equal(t, "NOT_CONNECTED", p.Status().Connection)
+	require.Error(t, p.Publish(context.Background(), []byte("hello")))
+}
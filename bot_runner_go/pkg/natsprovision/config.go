@@ -0,0 +1,151 @@
+package natsprovision
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type Config struct {
+	NatsURL string         `yaml:"nats_url"` // NATS 服务器地址
+	Streams []StreamConfig `yaml:"streams"`  // 需要创建或更新的流
+}
+
+type StreamConfig struct {
+	Name       string           `yaml:"name"`       // 流名称
+	Subjects   []string         `yaml:"subjects"`   // 捕获的主题
+	Retention  string           `yaml:"retention"`  // 保留策略：limits/interest/workqueue，默认 limits
+	Storage    string           `yaml:"storage"`    // 存储方式：file/memory，默认 file
+	MaxAge     time.Duration    `yaml:"max_age"`    // 消息最长保留时间，0 表示不限制
+	Duplicates time.Duration    `yaml:"duplicates"` // Nats-Msg-Id 去重窗口，0 表示使用服务器默认值
+	Consumers  []ConsumerConfig `yaml:"consumers"`  // 该流上需要创建或更新的持久化消费者
+}
+
+type ConsumerConfig struct {
+	Name          string        `yaml:"name"`           // 持久化消费者名称
+	FilterSubject string        `yaml:"filter_subject"` // 过滤主题
+	AckPolicy     string        `yaml:"ack_policy"`     // 确认策略：explicit/all/none，默认 explicit
+	DeliverPolicy string        `yaml:"deliver_policy"` // 投递策略：all/new/last，默认 all，创建后不可修改
+	MaxDeliver    int           `yaml:"max_deliver"`    // 最大投递次数，0 或 -1 表示不限制
+	AckWait       time.Duration `yaml:"ack_wait"`       // 确认超时时间，0 表示使用服务器默认值
+}
+
+func (c *Config) Validate() error {
+	if c.NatsURL == "" {
+		return errors.New("nats_url is required")
+	}
+	for i := range c.Streams {
+		if err := c.Streams[i].Validate(); err != nil {
+			return fmt.Errorf("streams[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (c *StreamConfig) Validate() error {
+	if c.Retention == "" {
+		c.Retention = "limits"
+	}
+	if c.Storage == "" {
+		c.Storage = "file"
+	}
+
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(c.Subjects) == 0 {
+		return errors.New("subjects is required")
+	}
+	if _, err := c.streamConfig(); err != nil {
+		return err
+	}
+	for i := range c.Consumers {
+		if err := c.Consumers[i].Validate(); err != nil {
+			return fmt.Errorf("consumers[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (c *ConsumerConfig) Validate() error {
+	if c.AckPolicy == "" {
+		c.AckPolicy = "explicit"
+	}
+	if c.DeliverPolicy == "" {
+		c.DeliverPolicy = "all"
+	}
+	if c.MaxDeliver == 0 {
+		c.MaxDeliver = -1
+	}
+
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if c.MaxDeliver < -1 {
+		return errors.New("max_deliver must be -1 or greater than 0")
+	}
+	_, err := c.consumerConfig()
+	return err
+}
+
+// streamConfig 转换为 JetStream 的流配置
+func (c *StreamConfig) streamConfig() (*jetstream.StreamConfig, error) {
+	cfg := &jetstream.StreamConfig{
+		Name:       c.Name,
+		Subjects:   c.Subjects,
+		MaxAge:     c.MaxAge,
+		Duplicates: c.Duplicates,
+	}
+	switch c.Retention {
+	case "limits":
+		cfg.Retention = jetstream.LimitsPolicy
+	case "interest":
+		cfg.Retention = jetstream.InterestPolicy
+	case "workqueue":
+		cfg.Retention = jetstream.WorkQueuePolicy
+	default:
+		return nil, fmt.Errorf("unknown retention: %s", c.Retention)
+	}
+	switch c.Storage {
+	case "file":
+		cfg.Storage = jetstream.FileStorage
+	case "memory":
+		cfg.Storage = jetstream.MemoryStorage
+	default:
+		return nil, fmt.Errorf("unknown storage: %s", c.Storage)
+	}
+	return cfg, nil
+}
+
+// consumerConfig 转换为 JetStream 的持久化消费者配置
+func (c *ConsumerConfig) consumerConfig() (*jetstream.ConsumerConfig, error) {
+	cfg := &jetstream.ConsumerConfig{
+		Durable:       c.Name,
+		FilterSubject: c.FilterSubject,
+		MaxDeliver:    c.MaxDeliver,
+		AckWait:       c.AckWait,
+	}
+	switch c.AckPolicy {
+	case "explicit":
+		cfg.AckPolicy = jetstream.AckExplicitPolicy
+	case "all":
+		cfg.AckPolicy = jetstream.AckAllPolicy
+	case "none":
+		cfg.AckPolicy = jetstream.AckNonePolicy
+	default:
+		return nil, fmt.Errorf("unknown ack_policy: %s", c.AckPolicy)
+	}
+	switch c.DeliverPolicy {
+	case "all":
+		cfg.DeliverPolicy = jetstream.DeliverAllPolicy
+	case "new":
+		cfg.DeliverPolicy = jetstream.DeliverNewPolicy
+	case "last":
+		cfg.DeliverPolicy = jetstream.DeliverLastPolicy
+	default:
+		return nil, fmt.Errorf("unknown deliver_policy: %s", c.DeliverPolicy)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,170 @@
+package natsprovision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsconn"
+)
+
+// Provision 按配置幂等地创建流与持久化消费者。已存在的流与消费者若与配置不一致，记录差异后按配置更新，
+// 更新失败（如修改了不可变更的字段）时保留线上配置继续运行
+func Provision(ctx context.Context, cfg *Config) error {
+	logger := zerolog.Ctx(ctx).With().Str("component", "natsprovision").Logger()
+	ctx = logger.WithContext(ctx)
+
+	nc, err := natsconn.Connect(cfg.NatsURL)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to connect to NATS server")
+		return err
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create JetStream context")
+		return err
+	}
+
+	for i := range cfg.Streams {
+		stream := &cfg.Streams[i]
+		if err := provisionStream(ctx, js, stream); err != nil {
+			return fmt.Errorf("failed to provision stream %s: %w", stream.Name, err)
+		}
+		for j := range stream.Consumers {
+			consumer := &stream.Consumers[j]
+			if err := provisionConsumer(ctx, js, stream.Name, consumer); err != nil {
+				return fmt.Errorf("failed to provision consumer %s on stream %s: %w", consumer.Name, stream.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func provisionStream(ctx context.Context, js jetstream.JetStream, c *StreamConfig) error {
+	logger := zerolog.Ctx(ctx).With().Str("stream", c.Name).Logger()
+	desired, err := c.streamConfig()
+	if err != nil {
+		return err
+	}
+
+	stream, err := js.Stream(ctx, c.Name)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		if _, err := js.CreateOrUpdateStream(ctx, *desired); err != nil {
+			return err
+		}
+		logger.Info().Strs("subjects", desired.Subjects).Msg("Stream created")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return err
+	}
+
+	drift := streamDrift(&info.Config, desired)
+	if len(drift) == 0 {
+		logger.Debug().Msg("Stream is up to date")
+		return nil
+	}
+	logger.Warn().Strs("drift", drift).Msg("Stream config has drifted from config file, updating")
+	// 在线上配置的基础上只修改配置文件管理的字段
+	updated := info.Config
+	updated.Subjects = desired.Subjects
+	updated.Retention = desired.Retention
+	updated.Storage = desired.Storage
+	updated.MaxAge = desired.MaxAge
+	if desired.Duplicates > 0 {
+		updated.Duplicates = desired.Duplicates
+	}
+	if _, err := js.CreateOrUpdateStream(ctx, updated); err != nil {
+		logger.Error().Err(err).Msg("Failed to update stream, keeping live config")
+		return nil
+	}
+	logger.Info().Msg("Stream updated")
+	return nil
+}
+
+func provisionConsumer(ctx context.Context, js jetstream.JetStream, stream string, c *ConsumerConfig) error {
+	logger := zerolog.Ctx(ctx).With().Str("stream", stream).Str("consumer_name", c.Name).Logger()
+	desired, err := c.consumerConfig()
+	if err != nil {
+		return err
+	}
+
+	consumer, err := js.Consumer(ctx, stream, c.Name)
+	if errors.Is(err, jetstream.ErrConsumerNotFound) {
+		if _, err := js.CreateOrUpdateConsumer(ctx, stream, *desired); err != nil {
+			return err
+		}
+		logger.Info().Str("filter_subject", desired.FilterSubject).Msg("Consumer created")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		return err
+	}
+
+	drift := consumerDrift(&info.Config, desired)
+	if len(drift) == 0 {
+		logger.Debug().Msg("Consumer is up to date")
+		return nil
+	}
+	logger.Warn().Strs("drift", drift).Msg("Consumer config has drifted from config file, updating")
+	updated := info.Config
+	updated.FilterSubject = desired.FilterSubject
+	updated.AckPolicy = desired.AckPolicy
+	updated.DeliverPolicy = desired.DeliverPolicy
+	updated.MaxDeliver = desired.MaxDeliver
+	if desired.AckWait > 0 {
+		updated.AckWait = desired.AckWait
+	}
+	if _, err := js.CreateOrUpdateConsumer(ctx, stream, updated); err != nil {
+		logger.Error().Err(err).Msg("Failed to update consumer, keeping live config")
+		return nil
+	}
+	logger.Info().Msg("Consumer updated")
+	return nil
+}
+
+type driftList []string
+
+func (d *driftList) check(field string, live, desired any, equal bool) {
+	if !equal {
+		*d = append(*d, fmt.Sprintf("%s: live=%v desired=%v", field, live, desired))
+	}
+}
+
+// streamDrift 比较配置文件管理的流字段，未配置的可选字段使用服务器默认值，不视为差异
+func streamDrift(live, desired *jetstream.StreamConfig) []string {
+	var d driftList
+	d.check("subjects", live.Subjects, desired.Subjects, slices.Equal(live.Subjects, desired.Subjects))
+	d.check("retention", live.Retention, desired.Retention, live.Retention == desired.Retention)
+	d.check("storage", live.Storage, desired.Storage, live.Storage == desired.Storage)
+	d.check("max_age", live.MaxAge, desired.MaxAge, live.MaxAge == desired.MaxAge)
+	if desired.Duplicates > 0 {
+		d.check("duplicates", live.Duplicates, desired.Duplicates, live.Duplicates == desired.Duplicates)
+	}
+	return d
+}
+
+// consumerDrift 比较配置文件管理的消费者字段，未配置的可选字段使用服务器默认值，不视为差异
+func consumerDrift(live, desired *jetstream.ConsumerConfig) []string {
+	var d driftList
+	d.check("filter_subject", live.FilterSubject, desired.FilterSubject, live.FilterSubject == desired.FilterSubject)
+	d.check("ack_policy", live.AckPolicy, desired.AckPolicy, live.AckPolicy == desired.AckPolicy)
+	d.check("deliver_policy", live.DeliverPolicy, desired.DeliverPolicy, live.DeliverPolicy == desired.DeliverPolicy)
+	d.check("max_deliver", live.MaxDeliver, desired.MaxDeliver, live.MaxDeliver == desired.MaxDeliver)
+	if desired.AckWait > 0 {
+		d.check("ack_wait", live.AckWait, desired.AckWait, live.AckWait == desired.AckWait)
+	}
+	return d
+}
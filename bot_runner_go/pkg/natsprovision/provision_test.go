@@ -0,0 +1,92 @@
+package natsprovision
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/testharness"
+)
+
+func TestStreamDrift(t *testing.T) {
+	cfg := &StreamConfig{Name: "BOTS_STREAM", Subjects: []string{"BOTS.*"}, MaxAge: time.Hour}
+	require.NoError(t, cfg.Validate())
+	desired, err := cfg.streamConfig()
+	require.NoError(t, err)
+
+	// 服务器为未配置的去重窗口填充了默认值，不视为差异
+	live := *desired
+	live.Duplicates = 2 * time.Minute
+	require.Empty(t, streamDrift(&live, desired))
+
+	live.Subjects = []string{"BOTS.received_msgs"}
+	live.Retention = jetstream.WorkQueuePolicy
+	require.Equal(t, []string{
+		"subjects: live=[BOTS.received_msgs] desired=[BOTS.*]",
+		"retention: live=WorkQueue desired=Limits",
+	}, streamDrift(&live, desired))
+}
+
+func TestConsumerDrift(t *testing.T) {
+	cfg := &ConsumerConfig{Name: "WX_MSGS_CONSUMER", FilterSubject: "BOTS.received_msgs"}
+	require.NoError(t, cfg.Validate())
+	desired, err := cfg.consumerConfig()
+	require.NoError(t, err)
+	require.Equal(t, -1, desired.MaxDeliver)
+
+	live := *desired
+	live.AckWait = 30 * time.Second
+	require.Empty(t, consumerDrift(&live, desired))
+
+	live.MaxDeliver = 5
+	require.Equal(t, []string{"max_deliver: live=5 desired=-1"}, consumerDrift(&live, desired))
+}
+
+func TestInvalidConfig(t *testing.T) {
+	cfg := &Config{NatsURL: "nats://127.0.0.1:4222", Streams: []StreamConfig{{
+		Name:      "BOTS_STREAM",
+		Subjects:  []string{"BOTS.*"},
+		Retention: "forever",
+	}}}
+	require.Error(t, cfg.Validate())
+}
+
+func TestProvision(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := logger.WithContext(context.Background())
+	cfg := &Config{NatsURL: srv.URL(), Streams: []StreamConfig{{
+		Name:     "BOTS_STREAM",
+		Subjects: []string{"BOTS.*"},
+		Consumers: []ConsumerConfig{
+			{Name: "WX_MSGS_CONSUMER", FilterSubject: "BOTS.received_msgs"},
+		},
+	}}}
+	require.NoError(t, cfg.Validate())
+
+	// 首次运行创建流与消费者，重复运行没有变化
+	require.NoError(t, Provision(ctx, cfg))
+	require.NoError(t, Provision(ctx, cfg))
+
+	// 配置文件修改后按配置更新线上配置
+	cfg.Streams[0].MaxAge = time.Hour
+	cfg.Streams[0].Consumers[0].AckWait = time.Minute
+	require.NoError(t, Provision(ctx, cfg))
+	js := srv.JetStream()
+	stream, err := js.Stream(ctx, "BOTS_STREAM")
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, stream.CachedInfo().Config.MaxAge)
+	consumer, err := js.Consumer(ctx, "BOTS_STREAM", "WX_MSGS_CONSUMER")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, consumer.CachedInfo().Config.AckWait)
+
+	// 不可变更的字段更新失败时保留线上配置
+	cfg.Streams[0].Consumers[0].DeliverPolicy = "new"
+	require.NoError(t, Provision(ctx, cfg))
+	consumer, err = js.Consumer(ctx, "BOTS_STREAM", "WX_MSGS_CONSUMER")
+	require.NoError(t, err)
+	require.Equal(t, jetstream.DeliverAllPolicy, consumer.CachedInfo().Config.DeliverPolicy)
+}
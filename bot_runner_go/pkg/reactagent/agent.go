@@ -2,133 +2,382 @@ package reactagent
 
 import (
 	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
 
 	"github.com/cloudwego/eino-ext/components/model/openai"
-	einomcp "github.com/cloudwego/eino-ext/components/tool/mcp"
+	"github.com/cloudwego/eino/callbacks"
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/compose"
-	"github.com/cloudwego/eino/flow/agent"
+	einoagent "github.com/cloudwego/eino/flow/agent"
 	"github.com/cloudwego/eino/flow/agent/react"
 	"github.com/cloudwego/eino/schema"
-	"github.com/mark3labs/mcp-go/client"
-	"github.com/mark3labs/mcp-go/client/transport"
-	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/chathistory"
 )
 
 type ReactAgent struct {
-	agent        *react.Agent
+	chatModel    *failoverModel // 支持故障转移的模型
 	systemPrompt string
+	history      *chathistory.History // 会话历史，为 nil 时不携带历史消息
+	mcp          *mcpManager          // MCP服务器连接管理
+	builtinTools []tool.BaseTool      // 启用的内置工具
+	authorizer   *toolAuthorizer      // 工具授权策略
+	limits       LimitsConfig         // ReAct 运行限制
+
+	mu           sync.Mutex
+	agent        *react.Agent // 根据当前可用工具构建的 ReAct Agent
+	toolsVersion uint64       // 构建 agent 时的工具集版本
+}
+
+type options struct {
+	messageSender MessageSender
+	chatLog       ChatLog
+	historyStores *chathistory.Stores
+}
+
+type Option func(*options)
+
+// WithMessageSender 提供消息发送能力，启用 send_message 内置工具时必须提供
+func WithMessageSender(sender MessageSender) Option {
+	return func(o *options) {
+		o.messageSender = sender
+	}
+}
+
+// WithChatLog 提供会话消息记录，启用 recent_messages 内置工具时必须提供
+func WithChatLog(log ChatLog) Option {
+	return func(o *options) {
+		o.chatLog = log
+	}
+}
+
+// WithHistoryStores 从共享的存储中打开会话历史，多个 ReactAgent 使用相同的存储配置时共享会话历史
+func WithHistoryStores(stores *chathistory.Stores) Option {
+	return func(o *options) {
+		o.historyStores = stores
+	}
 }
 
-func New(ctx context.Context, cfg *Config) (ret *ReactAgent, err error) {
+func New(ctx context.Context, cfg *Config, opts ...Option) (ret *ReactAgent, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	logger := zerolog.Ctx(ctx).With().Str("component", "reactagent").Logger()
-	// 初始化LLM
-	chatModel, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
-		BaseURL: cfg.Model.BaseURL,
-		APIKey:  cfg.Model.APIKey,
-		Model:   cfg.Model.Model,
-	})
-	if err != nil {
-		logger.Error().Err(err).Msg("failed to initialize chat model")
+	if err := cfg.Validate(); err != nil {
+		logger.Error().Err(err).Msg("invalid ReactAgent config")
 		return nil, err
 	}
 
-	var allTools []tool.BaseTool
-
-	// 初始化所有MCP工具
-	for _, mcpServerCfg := range cfg.MCPTools {
-		tp, err := transport.NewSSE(mcpServerCfg.BaseURL)
+	// 初始化所有LLM提供方，按优先级故障转移
+	var providers []*modelProvider
+	for _, modelCfg := range cfg.Models {
+		chatModel, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
+			BaseURL: modelCfg.BaseURL,
+			APIKey:  modelCfg.APIKey,
+			Model:   modelCfg.Model,
+			Timeout: modelCfg.Timeout,
+		})
 		if err != nil {
-			logger.Error().Err(err).Str("mcp_server", mcpServerCfg.Name).
-				Msg("failed to create SSE transport for MCP server")
+			logger.Error().Err(err).Str("model_provider", modelCfg.Name).Msg("failed to initialize chat model")
 			return nil, err
 		}
-		if err := tp.Start(ctx); err != nil {
-			logger.Error().Err(err).Str("mcp_server", mcpServerCfg.Name).
-				Msg("failed to start SSE transport for MCP server")
-			return nil, err
+		providers = append(providers, &modelProvider{
+			name:    modelCfg.Name,
+			model:   chatModel,
+			breaker: newCircuitBreaker(&cfg.CircuitBreaker),
+		})
+	}
+
+	authorizer, err := newToolAuthorizer(cfg.ToolPolicies)
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid tool policies")
+		return nil, err
+	}
+
+	var history *chathistory.History
+	if cfg.History != nil {
+		if o.historyStores != nil {
+			history, err = o.historyStores.New(ctx, cfg.History)
+		} else {
+			history, err = chathistory.New(ctx, cfg.History)
 		}
-		cli := client.NewClient(tp)
-		// 初始化MCP请求
-		initRequest := mcp.InitializeRequest{
-			Params: mcp.InitializeParams{
-				ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
-				ClientInfo: mcp.Implementation{
-					Name:    mcpServerCfg.Name,
-					Version: mcpServerCfg.Version,
-				},
-			},
-		}
-		_, err = cli.Initialize(ctx, initRequest)
 		if err != nil {
-			logger.Error().Err(err).Str("mcp_server", mcpServerCfg.Name).Msg("failed to initialize MCP client")
+			logger.Error().Err(err).Msg("failed to create chat history")
 			return nil, err
 		}
-		mcpTools, err := einomcp.GetTools(ctx, &einomcp.Config{
-			Cli:          cli,
-			ToolNameList: mcpServerCfg.ToolNameList,
-		})
-		if err != nil {
-			logger.Error().Err(err).Str("mcp_server", mcpServerCfg.Name).Msg("failed to get MCP tools")
-			return nil, err
+	}
+
+	builtinTools, err := newBuiltinTools(cfg.BuiltinTools, &BuiltinToolDeps{
+		MessageSender: o.messageSender,
+		ChatLog:       o.chatLog,
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to create builtin tools")
+		if history != nil {
+			history.Close()
 		}
-		allTools = append(allTools, mcpTools...)
+		return nil, err
 	}
 
-	if len(allTools) == 0 {
-		// 如果没有MCP工具，则添加一个占位符工具
-		logger.Warn().Msg("No MCP tools found, using placeholder tool")
-		allTools = append(allTools, &PlaceHolderTool{})
+	// MCP服务器在后台独立连接与重连，不可用的服务器不会阻塞启动
+	ret = &ReactAgent{
+		chatModel:    newFailoverModel(providers),
+		systemPrompt: cfg.SystemPrompt,
+		history:      history,
+		builtinTools: builtinTools,
+		authorizer:   authorizer,
+		limits:       cfg.Limits,
+		mcp:          newMCPManager(logger.WithContext(ctx), cfg.MCPTools, &cfg.MCPReconnect),
 	}
+	return
+}
 
+// getAgent 返回基于当前可用工具构建的 ReAct Agent，工具集发生变化时重新构建，没有任何可用工具时返回 nil
+func (r *ReactAgent) getAgent(ctx context.Context) (*react.Agent, error) {
+	logger := zerolog.Ctx(ctx)
+	mcpTools, version := r.mcp.Tools()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.agent != nil && r.toolsVersion == version {
+		return r.agent, nil
+	}
+
+	tools := append(append([]tool.BaseTool(nil), r.builtinTools...), mcpTools...)
+	// 调用指标在超时之内记录，超时的调用计为失败
+	tools, err := withToolMetrics(ctx, r.authorizer.guardTools(tools))
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to get tool info")
+		return nil, err
+	}
+	tools = withToolTimeout(tools, r.limits.ToolTimeout)
+	if len(tools) == 0 {
+		return nil, nil
+	}
 	agent, err := react.NewAgent(ctx, &react.AgentConfig{
-		MaxStep:          10,
-		ToolCallingModel: chatModel,
+		MaxStep:          r.limits.MaxSteps,
+		ToolCallingModel: r.chatModel,
 		ToolsConfig: compose.ToolsNodeConfig{
-			Tools: allTools,
+			Tools: tools,
 		},
+		StreamToolCallChecker: streamToolCallChecker,
 	})
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to create ReactAgent")
 		return nil, err
 	}
+	logger.Info().Int("tool_count", len(tools)).Uint64("tools_version", version).Msg("ReactAgent rebuilt with current tools")
+	r.agent = agent
+	r.toolsVersion = version
+	return agent, nil
+}
 
-	ret = &ReactAgent{
-		agent:        agent,
-		systemPrompt: cfg.SystemPrompt,
+// toolCallLookahead 模型开始输出文本后，继续查找工具调用的最大分片数
+const toolCallLookahead = 20
+
+// streamToolCallChecker 读取模型的流式输出，判断是否需要调用工具。
+// 许多 OpenAI 兼容的服务会在工具调用之前先输出一小段文本，eino 默认只检查第一个非空分片，
+// 会把这类输出当作最终回答；因此出现文本后再向后查找 toolCallLookahead 个分片，
+// 仍然没有工具调用时视为最终回答，回答可以尽快开始流式发送
+func streamToolCallChecker(ctx context.Context, sr *schema.StreamReader[*schema.Message]) (bool, error) {
+	defer sr.Close()
+	lookahead := -1 // 出现文本后剩余的查找分片数
+	for lookahead != 0 {
+		msg, err := sr.Recv()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if len(msg.ToolCalls) > 0 {
+			return true, nil
+		}
+		if lookahead > 0 {
+			lookahead--
+		} else if msg.Content != "" {
+			lookahead = toolCallLookahead
+		}
 	}
-	return
+	return false, nil
 }
 
-func (r *ReactAgent) Question(ctx context.Context, question string) (string, error) {
-	logger := zerolog.Ctx(ctx).With().Str("component", "reactagent").Logger()
-	logger.Info().Str("question", question).Msg("Processing question")
+// Question 处理用户问题，sessionKey 用于区分会话历史，同一会话的历史问答会作为上下文一并提交给模型。
+// 触发运行限制时返回 *LimitError，其中的 Partial 为根据已有推理得到的回答
+func (r *ReactAgent) Question(ctx context.Context, sessionKey string, question string) (string, error) {
+	return r.question(ctx, sessionKey, question, nil)
+}
 
-	// 使用 ReactAgent 处理用户问题
-	answer, err := r.agent.Generate(ctx, []*schema.Message{
-		schema.SystemMessage(r.systemPrompt),
-		schema.UserMessage(question),
-	}, agent.WithComposeOptions(compose.WithCallbacks(&LoggerCallback{})))
+// QuestionStream 以流式方式处理用户问题，最终回答的增量内容依次交给 onDelta，返回完整回答。
+// 触发运行限制时，根据已有推理得到的回答同样会交给 onDelta；onDelta 返回错误时中止处理
+func (r *ReactAgent) QuestionStream(ctx context.Context, sessionKey string, question string, onDelta func(delta string) error) (string, error) {
+	return r.question(ctx, sessionKey, question, onDelta)
+}
+
+func (r *ReactAgent) question(ctx context.Context, sessionKey string, question string, onDelta func(delta string) error) (_ string, retErr error) {
+	steps := 0
+	defer func() { recordQuestion(steps, retErr) }()
+	logger := zerolog.Ctx(ctx).With().Str("component", "reactagent").Str("session_key", sessionKey).Logger()
+	logger.Info().Str("question", question).Bool("stream", onDelta != nil).Msg("Processing question")
+
+	// 组装系统提示词、历史消息与本次问题
+	input := []*schema.Message{schema.SystemMessage(r.systemPrompt)}
+	if r.history != nil {
+		historyMsgs, err := r.history.Load(ctx, sessionKey)
+		if err != nil {
+			// 历史读取失败不影响本次回答
+			logger.Error().Err(err).Msg("Failed to load chat history")
+		}
+		logger.Debug().Int("history_messages", len(historyMsgs)).Msg("Loaded chat history")
+		input = append(input, historyMsgs...)
+	}
+	userMsg := schema.UserMessage(question)
+	input = append(input, userMsg)
 
+	agent, err := r.getAgent(ctx)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to process question")
 		return "", err
 	}
+	if agent == nil {
+		logger.Warn().Msg("No tools available, calling chat model directly")
+	}
+
+	// 单次提问的超时与 token 预算通过取消 runCtx 实现，取消原因用于区分触发的限制
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if r.limits.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		runCtx, cancelTimeout = context.WithTimeoutCause(runCtx, r.limits.Timeout, errQuestionTimeout)
+		defer cancelTimeout()
+	}
+	collector := newRunCollector(r.limits.TokenBudget, cancel)
 
+	// 使用 ReactAgent 处理用户问题，没有任何可用工具时直接调用模型
+	var answer *schema.Message
+	var streamed strings.Builder // 已交给 onDelta 的内容
+	if onDelta == nil {
+		answer, err = r.generate(runCtx, agent, input, collector)
+	} else {
+		answer, err = r.stream(runCtx, agent, input, collector, func(delta string) error {
+			streamed.WriteString(delta)
+			return onDelta(delta)
+		})
+	}
+	// 结束本次运行，等待回调记录完流式输出后再读取运行状态
+	cancel(nil)
+	collector.Wait()
+	steps = collector.Steps()
+
+	if err != nil {
+		kind, ok := limitKind(runCtx, err)
+		if !ok {
+			logger.Error().Err(err).Msg("Failed to process question")
+			return "", err
+		}
+		logger.Warn().Err(err).Str("limit", string(kind)).Int("used_tokens", collector.UsedTokens()).Msg("Question exceeded limit, answering from partial reasoning")
+		// 流式回答中途触发限制时，已发出的内容即为部分回答
+		partial := streamed.String()
+		if partial == "" {
+			partial = r.partialAnswer(ctx, kind, collector)
+			if partial != "" && onDelta != nil {
+				if err := onDelta(partial); err != nil {
+					return "", err
+				}
+			}
+		}
+		if partial == "" {
+			return "", &LimitError{Kind: kind, Err: err}
+		}
+		answer = schema.AssistantMessage(partial, nil)
+		err = &LimitError{Kind: kind, Partial: partial, Err: err}
+	}
+
+	// 记录本轮问答，供同一会话的后续问题使用
+	if r.history != nil {
+		turnID := CallerFromContext(ctx).MessageID
+		if err := r.history.AppendTurn(ctx, sessionKey, turnID, userMsg, schema.AssistantMessage(answer.Content, nil)); err != nil {
+			logger.Error().Err(err).Msg("Failed to save chat history")
+		}
+	}
+
+	if err != nil {
+		return answer.Content, err
+	}
 	logger.Info().Str("answer", answer.Content).Msg("Question processed successfully")
 	return answer.Content, nil
 }
 
-type PlaceHolderTool struct{}
+func (r *ReactAgent) generate(ctx context.Context, agent *react.Agent, input []*schema.Message, collector *runCollector) (*schema.Message, error) {
+	if agent == nil {
+		// 直接调用模型时同样通过回调记录 token 用量与输入输出，使运行限制生效
+		ctx = callbacks.InitCallbacks(ctx, nil, &LoggerCallback{}, collector.Handler())
+		return r.chatModel.Generate(ctx, input)
+	}
+	return agent.Generate(ctx, input, einoagent.WithComposeOptions(
+		compose.WithCallbacks(&LoggerCallback{}, collector.Handler()),
+	))
+}
 
-func (lt *PlaceHolderTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
-	return &schema.ToolInfo{
-		Name: "PlaceHolderTool",
-		Desc: "这仅是一个占位符工具，请不要调用它",
-	}, nil
+// stream 流式生成最终回答，逐个把非空的增量内容交给 onDelta，返回拼接后的完整回答
+func (r *ReactAgent) stream(ctx context.Context, agent *react.Agent, input []*schema.Message, collector *runCollector, onDelta func(delta string) error) (*schema.Message, error) {
+	var sr *schema.StreamReader[*schema.Message]
+	var err error
+	if agent == nil {
+		ctx = callbacks.InitCallbacks(ctx, nil, &LoggerCallback{}, collector.Handler())
+		sr, err = r.chatModel.Stream(ctx, input)
+	} else {
+		sr, err = agent.Stream(ctx, input, einoagent.WithComposeOptions(
+			compose.WithCallbacks(&LoggerCallback{}, collector.Handler()),
+		))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer sr.Close()
+
+	var chunks []*schema.Message
+	for {
+		chunk, err := sr.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+		if chunk.Content == "" {
+			continue
+		}
+		if err := onDelta(chunk.Content); err != nil {
+			return nil, err
+		}
+	}
+	if len(chunks) == 0 {
+		return nil, errors.New("empty stream from chat model")
+	}
+	return schema.ConcatMessages(chunks)
+}
+
+// ModelHealth 返回各模型提供方的健康状态，按优先级排列
+func (r *ReactAgent) ModelHealth() []ProviderHealth {
+	return r.chatModel.Health()
 }
 
-func (lt *PlaceHolderTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
-	return "", nil
+// MCPStatus 返回各MCP服务器的连接状态与已加载的工具
+func (r *ReactAgent) MCPStatus() []MCPServerStatus {
+	return r.mcp.Status()
+}
+
+// Close 释放 ReactAgent 持有的资源
+func (r *ReactAgent) Close() error {
+	r.mcp.Close()
+	if r.history != nil {
+		return r.history.Close()
+	}
+	return nil
 }
@@ -0,0 +1,264 @@
+package reactagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/components/tool/utils"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// MessageSender 发送消息到指定会话，由运行 Agent 的 runner 提供
+type MessageSender interface {
+	SendMessage(ctx context.Context, chatName string, content string) error
+}
+
+// ChatMessage 会话中收到的一条消息
+type ChatMessage struct {
+	Sender  string    `json:"sender"`  // 发送者
+	Content string    `json:"content"` // 消息内容
+	Time    time.Time `json:"time"`    // 收到消息的时间
+}
+
+// ChatLog 各会话最近收到的消息，包括没有交给 Agent 处理的消息，由运行 Agent 的 runner 记录
+type ChatLog interface {
+	// RecentMessages 返回会话中最近的至多 limit 条消息，按时间先后排列
+	RecentMessages(ctx context.Context, chatName string, limit int) ([]ChatMessage, error)
+}
+
+// BuiltinToolDeps 内置工具可使用的依赖，未提供的依赖为 nil
+type BuiltinToolDeps struct {
+	MessageSender MessageSender // 消息发送
+	ChatLog       ChatLog       // 会话消息记录
+}
+
+// BuiltinToolFactory 根据依赖创建内置工具，缺少必要依赖时返回错误
+type BuiltinToolFactory func(deps *BuiltinToolDeps) (tool.InvokableTool, error)
+
+var builtinToolRegistry = map[string]BuiltinToolFactory{
+	"current_time":    newCurrentTimeTool,
+	"calculator":      newCalculatorTool,
+	"recent_messages": newRecentMessagesTool,
+	"send_message":    newSendMessageTool,
+}
+
+// 未配置 builtin_tools 时默认启用的内置工具
+var defaultBuiltinTools = []string{"current_time", "calculator"}
+
+// RegisterBuiltinTool 注册内置工具，只应在 init 中调用，名称重复时 panic
+func RegisterBuiltinTool(name string, factory BuiltinToolFactory) {
+	if _, ok := builtinToolRegistry[name]; ok {
+		panic(fmt.Sprintf("builtin tool %s already registered", name))
+	}
+	builtinToolRegistry[name] = factory
+}
+
+// BuiltinToolNames 返回所有已注册的内置工具名称
+func BuiltinToolNames() []string {
+	names := make([]string, 0, len(builtinToolRegistry))
+	for name := range builtinToolRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newBuiltinTools(names []string, deps *BuiltinToolDeps) ([]tool.BaseTool, error) {
+	tools := make([]tool.BaseTool, 0, len(names))
+	for _, name := range names {
+		factory, ok := builtinToolRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown builtin tool: %s", name)
+		}
+		t, err := factory(deps)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create builtin tool %s: %w", name, err)
+		}
+		tools = append(tools, t)
+	}
+	return tools, nil
+}
+
+type currentTimeInput struct {
+	Timezone string `json:"timezone,omitempty" jsonschema:"description=IANA 时区名称，如 Asia/Shanghai，为空时使用服务器本地时区"`
+}
+
+type currentTimeOutput struct {
+	Time     string `json:"time,omitempty"`
+	Weekday  string `json:"weekday,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newCurrentTimeTool(deps *BuiltinToolDeps) (tool.InvokableTool, error) {
+	return utils.InferTool("current_time", "获取当前的日期、时间与星期，可指定时区",
+		func(ctx context.Context, input currentTimeInput) (*currentTimeOutput, error) {
+			loc := time.Local
+			if input.Timezone != "" {
+				var err error
+				if loc, err = time.LoadLocation(input.Timezone); err != nil {
+					return &currentTimeOutput{Error: "未知的时区：" + input.Timezone}, nil
+				}
+			}
+			now := time.Now().In(loc)
+			return &currentTimeOutput{
+				Time:     now.Format(time.DateTime),
+				Weekday:  now.Weekday().String(),
+				Timezone: loc.String(),
+			}, nil
+		})
+}
+
+type calculatorInput struct {
+	Expression string `json:"expression" jsonschema:"description=数学表达式，支持 + - * / % ** 以及 abs ceil floor round max min 函数，如 (1 + 2) * 3"`
+}
+
+type calculatorOutput struct {
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// calculatorMaxLength 表达式的最大长度
+const calculatorMaxLength = 256
+
+var (
+	// calculatorOperators 允许的运算符，不包括 .. 等会构造字符串或数组的运算符
+	calculatorOperators = map[string]bool{"+": true, "-": true, "*": true, "/": true, "%": true, "**": true, "^": true}
+	// calculatorFunctions 允许的函数，其余内置函数全部禁用
+	calculatorFunctions = []string{"abs", "ceil", "floor", "round", "max", "min"}
+)
+
+func newCalculatorTool(deps *BuiltinToolDeps) (tool.InvokableTool, error) {
+	opts := []expr.Option{expr.Env(map[string]any{}), expr.DisableAllBuiltins()}
+	for _, name := range calculatorFunctions {
+		opts = append(opts, expr.EnableBuiltin(name))
+	}
+	return utils.InferTool("calculator", "计算数学表达式的结果",
+		func(ctx context.Context, input calculatorInput) (*calculatorOutput, error) {
+			// 表达式来自模型，可能由任意群成员诱导生成，编译前只允许数字、算术运算符与数学函数，
+			// 避免构造超大的字符串或数组、长时间占用 CPU
+			if len(input.Expression) > calculatorMaxLength {
+				return &calculatorOutput{Error: fmt.Sprintf("expression is longer than %d characters", calculatorMaxLength)}, nil
+			}
+			tree, err := parser.Parse(input.Expression)
+			if err != nil {
+				return &calculatorOutput{Error: err.Error()}, nil
+			}
+			var checker calculatorChecker
+			ast.Walk(&tree.Node, &checker)
+			if checker.err != nil {
+				return &calculatorOutput{Error: checker.err.Error()}, nil
+			}
+
+			program, err := expr.Compile(input.Expression, opts...)
+			if err != nil {
+				return &calculatorOutput{Error: err.Error()}, nil
+			}
+			result, err := expr.Run(program, map[string]any{})
+			if err != nil {
+				return &calculatorOutput{Error: err.Error()}, nil
+			}
+			switch v := result.(type) {
+			case int:
+			case float64:
+				// 除以 0、溢出等得到的 ±Inf 与 NaN 无法编码为 JSON，作为错误返回给模型
+				if math.IsInf(v, 0) || math.IsNaN(v) {
+					return &calculatorOutput{Error: "result is not a finite number"}, nil
+				}
+			default:
+				return &calculatorOutput{Error: "result is not a number"}, nil
+			}
+			return &calculatorOutput{Result: result}, nil
+		})
+}
+
+// calculatorChecker 检查表达式只包含数字、算术运算符与允许的数学函数
+type calculatorChecker struct {
+	err error
+}
+
+func (c *calculatorChecker) Visit(node *ast.Node) {
+	if c.err != nil {
+		return
+	}
+	switch n := (*node).(type) {
+	case *ast.IntegerNode, *ast.FloatNode:
+	case *ast.UnaryNode:
+		if n.Operator != "-" && n.Operator != "+" {
+			c.err = fmt.Errorf("operator %s is not allowed", n.Operator)
+		}
+	case *ast.BinaryNode:
+		if !calculatorOperators[n.Operator] {
+			c.err = fmt.Errorf("operator %s is not allowed", n.Operator)
+		}
+	case *ast.BuiltinNode:
+		if !slices.Contains(calculatorFunctions, n.Name) {
+			c.err = fmt.Errorf("function %s is not allowed", n.Name)
+		}
+	default:
+		c.err = errors.New("only numbers, arithmetic operators and math functions are allowed")
+	}
+}
+
+type recentMessagesInput struct {
+	Limit int `json:"limit,omitempty" jsonschema:"description=返回的最大消息条数，默认 20"`
+}
+
+type recentMessagesOutput struct {
+	Messages []ChatMessage `json:"messages"`
+}
+
+func newRecentMessagesTool(deps *BuiltinToolDeps) (tool.InvokableTool, error) {
+	if deps.ChatLog == nil {
+		return nil, fmt.Errorf("chat log is not provided")
+	}
+	return utils.InferTool("recent_messages", "获取当前会话中最近的聊天消息，包括其他人之间的对话",
+		func(ctx context.Context, input recentMessagesInput) (*recentMessagesOutput, error) {
+			chatName := CallerFromContext(ctx).ChatName
+			if chatName == "" {
+				return &recentMessagesOutput{}, nil
+			}
+			if input.Limit <= 0 {
+				input.Limit = 20
+			}
+			msgs, err := deps.ChatLog.RecentMessages(ctx, chatName, input.Limit)
+			if err != nil {
+				return nil, err
+			}
+			return &recentMessagesOutput{Messages: msgs}, nil
+		})
+}
+
+type sendMessageInput struct {
+	ChatName string `json:"chat_name" jsonschema:"description=接收消息的好友或群聊名称"`
+	Content  string `json:"content" jsonschema:"description=消息内容"`
+}
+
+type sendMessageOutput struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newSendMessageTool(deps *BuiltinToolDeps) (tool.InvokableTool, error) {
+	if deps.MessageSender == nil {
+		return nil, fmt.Errorf("message sender is not provided")
+	}
+	return utils.InferTool("send_message", "向指定的好友或群聊发送一条消息",
+		func(ctx context.Context, input sendMessageInput) (*sendMessageOutput, error) {
+			if input.ChatName == "" || input.Content == "" {
+				return &sendMessageOutput{Error: "chat_name 与 content 不能为空"}, nil
+			}
+			if err := deps.MessageSender.SendMessage(ctx, input.ChatName, input.Content); err != nil {
+				return &sendMessageOutput{Error: err.Error()}, nil
+			}
+			return &sendMessageOutput{Success: true}, nil
+		})
+}
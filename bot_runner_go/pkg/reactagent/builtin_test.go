@@ -0,0 +1,117 @@
+package reactagent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMessageSender struct {
+	chatName string
+	content  string
+}
+
+func (s *fakeMessageSender) SendMessage(ctx context.Context, chatName string, content string) error {
+	s.chatName, s.content = chatName, content
+	return nil
+}
+
+type fakeChatLog struct {
+	msgs map[string][]ChatMessage
+}
+
+func (l *fakeChatLog) RecentMessages(ctx context.Context, chatName string, limit int) ([]ChatMessage, error) {
+	msgs := l.msgs[chatName]
+	return msgs[max(len(msgs)-limit, 0):], nil
+}
+
+func TestBuiltinToolRegistry(t *testing.T) {
+	ctx := context.Background()
+	sender := &fakeMessageSender{}
+
+	tools, err := newBuiltinTools(BuiltinToolNames(), &BuiltinToolDeps{
+		MessageSender: sender,
+		ChatLog:       &fakeChatLog{},
+	})
+	require.NoError(t, err)
+	var names []string
+	for _, tl := range tools {
+		info, err := tl.Info(ctx)
+		require.NoError(t, err)
+		names = append(names, info.Name)
+	}
+	require.Equal(t, BuiltinToolNames(), names)
+}
+
+func TestCalculatorTool(t *testing.T) {
+	calc, err := newCalculatorTool(&BuiltinToolDeps{})
+	require.NoError(t, err)
+
+	out, err := calc.InvokableRun(context.Background(), `{"expression": "(1 + 2) * 3"}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"result": 9}`, out)
+
+	// 表达式错误时把错误信息返回给模型，而不是中断 Agent
+	out, err = calc.InvokableRun(context.Background(), `{"expression": "foo + 1"}`)
+	require.NoError(t, err)
+	require.Contains(t, out, "error")
+
+	// 结果不是有限数时返回错误，而不是因为无法编码 JSON 中断 Agent
+	for _, expression := range []string{"1/0", "0/0", "10**1000"} {
+		out, err = calc.InvokableRun(context.Background(), `{"expression": "`+expression+`"}`)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"error": "result is not a finite number"}`, out)
+	}
+
+	out, err = calc.InvokableRun(context.Background(), `{"expression": "floor(max(-2.5, abs(-3)) ** 2) + 7 % 3"}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"result": 10}`, out)
+
+	// 只允许数字、算术运算符与数学函数，不能构造超大的字符串或数组
+	for _, expression := range []string{`repeat(\"a\", 1e9)`, "1..100000000", "map(1..10, # * 2)", `\"a\" + \"b\"`, "1 > 0"} {
+		out, err = calc.InvokableRun(context.Background(), `{"expression": "`+expression+`"}`)
+		require.NoError(t, err)
+		require.Contains(t, out, "error", expression)
+		require.NotContains(t, out, "result", expression)
+	}
+	out, err = calc.InvokableRun(context.Background(), `{"expression": "`+strings.Repeat("1+", 200)+`1"}`)
+	require.NoError(t, err)
+	require.Contains(t, out, "longer than")
+}
+
+func TestRecentMessagesTool(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	log := &fakeChatLog{msgs: map[string][]ChatMessage{
+		"测试群": {
+			{Sender: "alice", Content: "中午吃什么", Time: now},
+			{Sender: "bob", Content: "火锅", Time: now.Add(time.Minute)},
+		},
+	}}
+	recent, err := newRecentMessagesTool(&BuiltinToolDeps{ChatLog: log})
+	require.NoError(t, err)
+
+	// 返回提问所在会话最近的消息
+	ctx := WithCaller(context.Background(), &Caller{Sender: "alice", ChatName: "测试群"})
+	out, err := recent.InvokableRun(ctx, `{"limit": 1}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"messages": [{"sender": "bob", "content": "火锅", "time": "2025-01-01T12:01:00Z"}]}`, out)
+
+	// 未提供会话消息记录时无法启用
+	_, err = newRecentMessagesTool(&BuiltinToolDeps{})
+	require.Error(t, err)
+}
+
+func TestSendMessageTool(t *testing.T) {
+	sender := &fakeMessageSender{}
+	send, err := newSendMessageTool(&BuiltinToolDeps{MessageSender: sender})
+	require.NoError(t, err)
+
+	out, err := send.InvokableRun(context.Background(), `{"chat_name": "测试群", "content": "hello"}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"success": true}`, out)
+	require.Equal(t, "测试群", sender.chatName)
+	require.Equal(t, "hello", sender.content)
+}
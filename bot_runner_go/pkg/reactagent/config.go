@@ -1,20 +1,209 @@
 package reactagent
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/chathistory"
+)
+
 type Config struct {
-	SystemPrompt string      `yaml:"system_prompt"` // 系统提示词
-	Model        ModelConfig `yaml:"model"`         // 模型配置
-	MCPTools     []MCPServer `yaml:"mcp_tools"`     // MCP 工具配置
+	SystemPrompt   string               `yaml:"system_prompt"`   // 系统提示词
+	Model          ModelConfig          `yaml:"model"`           // 模型配置，仅使用单个模型时配置
+	Models         []ModelConfig        `yaml:"models"`          // 多个模型提供方，按优先级依次故障转移，配置后忽略 model
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"` // 模型提供方熔断配置
+	BuiltinTools   []string             `yaml:"builtin_tools"`   // 启用的内置工具名称，不配置时启用 current_time 与 calculator
+	MCPTools       []MCPServer          `yaml:"mcp_tools"`       // MCP 工具配置
+	MCPReconnect   MCPReconnectConfig   `yaml:"mcp_reconnect"`   // MCP 服务器连接与重连配置
+	ToolPolicies   []ToolPolicyConfig   `yaml:"tool_policies"`   // 工具授权策略，未命中任何策略的工具默认允许
+	History        *chathistory.Config  `yaml:"history"`         // 会话历史配置，不配置则不携带历史消息
+	Limits         LimitsConfig         `yaml:"limits"`          // ReAct 运行限制
+}
+
+func (c *Config) Validate() error {
+	if len(c.Models) == 0 {
+		c.Models = []ModelConfig{c.Model}
+	}
+	// 名称用于指标标签、熔断状态与日志，必须唯一
+	names := make(map[string]int, len(c.Models))
+	for i := range c.Models {
+		m := &c.Models[i]
+		if m.Name == "" {
+			m.Name = m.Model
+		}
+		if m.Model == "" {
+			return fmt.Errorf("models[%d]: model is required", i)
+		}
+		if j, ok := names[m.Name]; ok {
+			return fmt.Errorf("models[%d]: duplicate name %q, already used by models[%d]", i, m.Name, j)
+		}
+		names[m.Name] = i
+	}
+	if c.BuiltinTools == nil {
+		c.BuiltinTools = append([]string(nil), defaultBuiltinTools...)
+	}
+	for _, name := range c.BuiltinTools {
+		if _, ok := builtinToolRegistry[name]; !ok {
+			return fmt.Errorf("unknown builtin tool: %s, available: %v", name, BuiltinToolNames())
+		}
+	}
+	for i := range c.MCPTools {
+		if err := c.MCPTools[i].Validate(); err != nil {
+			return fmt.Errorf("mcp_tools[%d]: %w", i, err)
+		}
+	}
+	if err := c.MCPReconnect.Validate(); err != nil {
+		return fmt.Errorf("mcp_reconnect: %w", err)
+	}
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	// 按优先级排序，优先级相同时保持配置顺序
+	sort.SliceStable(c.Models, func(i, j int) bool {
+		return c.Models[i].Priority < c.Models[j].Priority
+	})
+	return c.CircuitBreaker.Validate()
 }
 
+type MCPTransport string
+
+const (
+	MCPTransportSSE            MCPTransport = "sse"             // HTTP Server-Sent Events
+	MCPTransportStreamableHTTP MCPTransport = "streamable_http" // Streamable HTTP
+	MCPTransportStdio          MCPTransport = "stdio"           // 本地子进程标准输入输出
+)
+
 type MCPServer struct {
-	Name         string   `yaml:"name"`           // MCP 服务器名称
-	Version      string   `yaml:"version"`        // MCP 服务器版本
-	BaseURL      string   `yaml:"base_url"`       // MCP 服务器基础 URL
-	ToolNameList []string `yaml:"tool_name_list"` // 过滤所需 MCP 工具名称列表
+	Name         string            `yaml:"name"`           // MCP 服务器名称
+	Version      string            `yaml:"version"`        // MCP 服务器版本
+	Transport    MCPTransport      `yaml:"transport"`      // 传输方式：sse/streamable_http/stdio，默认 sse
+	BaseURL      string            `yaml:"base_url"`       // sse/streamable_http：MCP 服务器基础 URL
+	Headers      map[string]string `yaml:"headers"`        // sse/streamable_http：附加的 HTTP 请求头
+	Command      string            `yaml:"command"`        // stdio：启动 MCP 服务器的命令
+	Args         []string          `yaml:"args"`           // stdio：命令参数
+	Env          map[string]string `yaml:"env"`            // stdio：附加的环境变量
+	ToolNameList []string          `yaml:"tool_name_list"` // 过滤所需 MCP 工具名称列表
+}
+
+func (c *MCPServer) Validate() error {
+	if c.Transport == "" {
+		c.Transport = MCPTransportSSE
+	}
+
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	switch c.Transport {
+	case MCPTransportSSE, MCPTransportStreamableHTTP:
+		if c.BaseURL == "" {
+			return fmt.Errorf("base_url is required for %s transport", c.Transport)
+		}
+	case MCPTransportStdio:
+		if c.Command == "" {
+			return errors.New("command is required for stdio transport")
+		}
+	default:
+		return fmt.Errorf("unknown transport: %s", c.Transport)
+	}
+	return nil
+}
+
+type MCPReconnectConfig struct {
+	InitialBackoff      time.Duration `yaml:"initial_backoff"`       // 首次重连等待时间
+	MaxBackoff          time.Duration `yaml:"max_backoff"`           // 最大重连等待时间，每次失败等待时间翻倍
+	ConnectTimeout      time.Duration `yaml:"connect_timeout"`       // 初始化握手、拉取工具列表与探活的超时时间
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"` // 探活间隔
+}
+
+func (c *MCPReconnectConfig) Validate() error {
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = 1 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 1 * time.Minute
+	}
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = 30 * time.Second
+	}
+	if c.HealthCheckInterval <= 0 {
+		c.HealthCheckInterval = 30 * time.Second
+	}
+
+	if c.MaxBackoff < c.InitialBackoff {
+		return errors.New("max_backoff must not be less than initial_backoff")
+	}
+	return nil
+}
+
+// LimitsConfig ReAct 运行限制，触发限制时根据目前已有的推理给出回答
+type LimitsConfig struct {
+	MaxSteps           int           `yaml:"max_steps"`            // 最大步数，每次模型调用与每轮工具调用各计一步，默认 10
+	Timeout            time.Duration `yaml:"timeout"`              // 单次提问的超时时间，默认不限制
+	ToolTimeout        time.Duration `yaml:"tool_timeout"`         // 单次工具调用的超时时间，默认不限制
+	TokenBudget        int           `yaml:"token_budget"`         // 单次提问所有模型调用的 token 总预算，默认不限制
+	FinalAnswerTimeout time.Duration `yaml:"final_answer_timeout"` // 触发限制后根据已有推理生成回答的超时时间
+}
+
+func (c *LimitsConfig) Validate() error {
+	if c.MaxSteps == 0 {
+		c.MaxSteps = 10
+	}
+	if c.FinalAnswerTimeout <= 0 {
+		c.FinalAnswerTimeout = 30 * time.Second
+	}
+
+	if c.MaxSteps < 2 {
+		return errors.New("max_steps must be at least 2")
+	}
+	if c.Timeout < 0 || c.ToolTimeout < 0 {
+		return errors.New("timeout and tool_timeout must not be negative")
+	}
+	if c.TokenBudget < 0 {
+		return errors.New("token_budget must not be negative")
+	}
+	return nil
+}
+
+// ToolPolicyConfig 工具授权策略，工具命中的所有策略都允许时才可执行。
+// 发送者名单同时匹配微信名与备注，先检查拒绝名单，再检查允许名单（为空时不限制），最后检查 expr 规则
+type ToolPolicyConfig struct {
+	Tools        []string `yaml:"tools"`         // 策略适用的工具名称，支持 * ? 通配符
+	AllowSenders []string `yaml:"allow_senders"` // 允许的发送者
+	DenySenders  []string `yaml:"deny_senders"`  // 拒绝的发送者
+	AllowChats   []string `yaml:"allow_chats"`   // 允许的会话名称
+	DenyChats    []string `yaml:"deny_chats"`    // 拒绝的会话名称
+	Rule         string   `yaml:"rule"`          // expr 规则，可使用 Tool、Sender、SenderRemark、ChatName
 }
 
 type ModelConfig struct {
-	BaseURL string `yaml:"base_url"` // 基础 URL
-	APIKey  string `yaml:"api_key"`  // API Key
-	Model   string `yaml:"model"`    // 模型名称
+	Name     string        `yaml:"name"`     // 提供方名称，用于日志、指标与健康状态，必须唯一，默认使用模型名称
+	Priority int           `yaml:"priority"` // 优先级，数值越小越优先
+	BaseURL  string        `yaml:"base_url"` // 基础 URL
+	APIKey   string        `yaml:"api_key"`  // API Key
+	Model    string        `yaml:"model"`    // 模型名称
+	Timeout  time.Duration `yaml:"timeout"`  // 单次请求超时时间，默认不超时
+}
+
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"` // 连续失败多少次后熔断
+	CoolDown         time.Duration `yaml:"cool_down"`         // 熔断后跳过该提供方的冷却时间
+}
+
+func (c *CircuitBreakerConfig) Validate() error {
+	if c.FailureThreshold == 0 {
+		c.FailureThreshold = 3
+	}
+	if c.CoolDown == 0 {
+		c.CoolDown = 30 * time.Second
+	}
+
+	if c.FailureThreshold <= 0 {
+		return errors.New("failure_threshold must be greater than 0")
+	}
+	if c.CoolDown <= 0 {
+		return errors.New("cool_down must be greater than 0")
+	}
+	return nil
 }
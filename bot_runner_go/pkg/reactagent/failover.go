@@ -0,0 +1,337 @@
+package reactagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	goopenai "github.com/meguminnnnnnnnn/go-openai"
+	"github.com/rs/zerolog"
+)
+
+// ErrNoAvailableModel 所有模型提供方都处于熔断状态
+var ErrNoAvailableModel = errors.New("no available model provider, all providers are in cool-down")
+
+// ProviderHealth 模型提供方的健康状态
+type ProviderHealth struct {
+	Name                string    `json:"name"`                 // 提供方名称
+	Available           bool      `json:"available"`            // 当前是否可用
+	ConsecutiveFailures int       `json:"consecutive_failures"` // 连续失败次数
+	OpenUntil           time.Time `json:"open_until,omitzero"`  // 熔断结束时间
+	LastError           string    `json:"last_error,omitempty"` // 最近一次失败原因
+}
+
+// circuitBreaker 连续失败达到阈值后熔断，冷却期内跳过该提供方；冷却结束后进入半开状态，
+// 只放行一个探测请求，探测成功后恢复，失败后重新熔断
+type circuitBreaker struct {
+	cfg *CircuitBreakerConfig
+	now func() time.Time
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	openUntil           time.Time // 熔断结束时间，非零且已过期时处于半开状态
+	probing             bool      // 半开状态下已放行探测请求，尚未得到结果
+	lastErr             error
+}
+
+func newCircuitBreaker(cfg *CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg, now: time.Now}
+}
+
+// Allow 判断能否调用该提供方，半开状态下获得探测名额的调用方必须以 Success、Failure 或 Release 报告结果
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutiveFailures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+	b.lastErr = nil
+}
+
+// Failure 记录一次失败，返回本次失败是否触发了熔断，探测请求失败时立即重新熔断
+func (b *circuitBreaker) Failure(err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutiveFailures++
+	b.lastErr = err
+	if b.probing || b.consecutiveFailures >= b.cfg.FailureThreshold {
+		b.openUntil = b.now().Add(b.cfg.CoolDown)
+		b.probing = false
+		return true
+	}
+	return false
+}
+
+// Release 调用结果不能说明提供方是否可用（如调用方取消）时释放探测名额，不改变熔断状态
+func (b *circuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) Health() ProviderHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := ProviderHealth{
+		Available:           b.openUntil.IsZero() || (!b.now().Before(b.openUntil) && !b.probing),
+		ConsecutiveFailures: b.consecutiveFailures,
+	}
+	if !b.openUntil.IsZero() {
+		h.OpenUntil = b.openUntil
+	}
+	if b.lastErr != nil {
+		h.LastError = b.lastErr.Error()
+	}
+	return h
+}
+
+type modelProvider struct {
+	name    string
+	model   model.ToolCallingChatModel
+	breaker *circuitBreaker
+}
+
+var _ model.ToolCallingChatModel = (*failoverModel)(nil)
+
+// failoverModel 按优先级依次调用模型提供方，遇到 5xx、超时、限流等错误时切换到下一个提供方。
+// 回调由 failoverModel 触发，失败的尝试不触发回调，每次调用只记录一次最终成功的输出
+type failoverModel struct {
+	providers []*modelProvider
+	tools     []*schema.ToolInfo
+}
+
+func newFailoverModel(providers []*modelProvider) *failoverModel {
+	return &failoverModel{providers: providers}
+}
+
+func (f *failoverModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	ctx = callbacks.EnsureRunInfo(ctx, f.GetType(), components.ComponentOfChatModel)
+	ctx = callbacks.OnStart(ctx, f.callbackInput(input, opts...))
+	providerCtx := withoutCallbacks(ctx)
+	msg, err := callWithFailover(providerCtx, f.providers, func(p *modelProvider) (*schema.Message, error) {
+		msg, err := p.model.Generate(providerCtx, input, opts...)
+		if err == nil {
+			recordTokenUsage(p.name, msg)
+		}
+		return msg, err
+	})
+	if err != nil {
+		callbacks.OnError(ctx, err)
+		return nil, err
+	}
+	callbacks.OnEnd(ctx, &model.CallbackOutput{Message: msg, TokenUsage: callbackTokenUsage(msg)})
+	return msg, nil
+}
+
+// Stream 只在建立流之前进行故障转移，流建立后读取分片出错时计入该提供方的失败次数，错误由调用方处理
+func (f *failoverModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	ctx = callbacks.EnsureRunInfo(ctx, f.GetType(), components.ComponentOfChatModel)
+	ctx = callbacks.OnStart(ctx, f.callbackInput(input, opts...))
+	providerCtx := withoutCallbacks(ctx)
+	sr, err := callWithFailover(providerCtx, f.providers, func(p *modelProvider) (*schema.StreamReader[*schema.Message], error) {
+		sr, err := p.model.Stream(providerCtx, input, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return watchStream(providerCtx, p, sr), nil
+	})
+	if err != nil {
+		callbacks.OnError(ctx, err)
+		return nil, err
+	}
+
+	_, cbsr := callbacks.OnEndWithStreamOutput(ctx, schema.StreamReaderWithConvert(sr,
+		func(chunk *schema.Message) (callbacks.CallbackOutput, error) {
+			return &model.CallbackOutput{Message: chunk, TokenUsage: callbackTokenUsage(chunk)}, nil
+		}))
+	return schema.StreamReaderWithConvert(cbsr, func(output callbacks.CallbackOutput) (*schema.Message, error) {
+		return output.(*model.CallbackOutput).Message, nil
+	}), nil
+}
+
+// watchStream 读取分片时记录 token 用量，读取出错时计入提供方的失败次数
+func watchStream(ctx context.Context, p *modelProvider, sr *schema.StreamReader[*schema.Message]) *schema.StreamReader[*schema.Message] {
+	out, sw := schema.Pipe[*schema.Message](1)
+	go func() {
+		defer sr.Close()
+		defer sw.Close()
+		for {
+			chunk, err := sr.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				// 与建立流时一致，调用方取消与非可重试错误（如请求参数错误）不计入熔断
+				if ctx.Err() == nil && isRetryableModelError(err) {
+					logger := zerolog.Ctx(ctx)
+					if p.breaker.Failure(err) {
+						logger.Warn().Err(err).Str("model_provider", p.name).Msg("Model provider stream failed too many times, circuit opened")
+					} else {
+						logger.Warn().Err(err).Str("model_provider", p.name).Msg("Model provider stream failed")
+					}
+				}
+				sw.Send(nil, err)
+				return
+			}
+			recordTokenUsage(p.name, chunk)
+			// 调用方已关闭流
+			if sw.Send(chunk, nil) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// WithTools 为每个提供方绑定工具，新的实例与原实例共享健康状态
+func (f *failoverModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	providers := make([]*modelProvider, 0, len(f.providers))
+	for _, p := range f.providers {
+		m, err := p.model.WithTools(tools)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind tools for model provider %s: %w", p.name, err)
+		}
+		providers = append(providers, &modelProvider{
+			name:    p.name,
+			model:   m,
+			breaker: p.breaker,
+		})
+	}
+	return &failoverModel{providers: providers, tools: tools}, nil
+}
+
+func (f *failoverModel) GetType() string {
+	return "Failover"
+}
+
+// IsCallbacksEnabled 回调由 failoverModel 自行触发，各提供方的模型不触发回调
+func (f *failoverModel) IsCallbacksEnabled() bool {
+	return true
+}
+
+func (f *failoverModel) callbackInput(input []*schema.Message, opts ...model.Option) *model.CallbackInput {
+	options := model.GetCommonOptions(&model.Options{Tools: f.tools}, opts...)
+	return &model.CallbackInput{
+		Messages: input,
+		Tools:    options.Tools,
+	}
+}
+
+// withoutCallbacks 去掉 ctx 中的回调，避免各提供方的模型为失败的尝试触发回调
+func withoutCallbacks(ctx context.Context) context.Context {
+	return callbacks.InitCallbacks(ctx, nil)
+}
+
+func callbackTokenUsage(msg *schema.Message) *model.TokenUsage {
+	if msg == nil || msg.ResponseMeta == nil || msg.ResponseMeta.Usage == nil {
+		return nil
+	}
+	usage := msg.ResponseMeta.Usage
+	return &model.TokenUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
+
+func (f *failoverModel) Health() []ProviderHealth {
+	ret := make([]ProviderHealth, 0, len(f.providers))
+	for _, p := range f.providers {
+		h := p.breaker.Health()
+		h.Name = p.name
+		ret = append(ret, h)
+	}
+	return ret
+}
+
+func callWithFailover[T any](ctx context.Context, providers []*modelProvider, call func(p *modelProvider) (T, error)) (ret T, err error) {
+	logger := zerolog.Ctx(ctx)
+
+	var errs []error
+	for _, p := range providers {
+		if !p.breaker.Allow() {
+			logger.Debug().Str("model_provider", p.name).Msg("Model provider is in cool-down, skipping")
+			continue
+		}
+
+		ret, err = call(p)
+		llmCalls.WithLabelValues(p.name, resultLabel(err)).Inc()
+		if err == nil {
+			p.breaker.Success()
+			return ret, nil
+		}
+		// 调用方已取消或超时，没有必要继续尝试
+		if ctx.Err() != nil {
+			p.breaker.Release()
+			return ret, err
+		}
+		// 非可重试错误（如请求参数错误）直接返回，不计入熔断
+		if !isRetryableModelError(err) {
+			p.breaker.Release()
+			return ret, err
+		}
+
+		if p.breaker.Failure(err) {
+			logger.Warn().Err(err).Str("model_provider", p.name).Msg("Model provider failed too many times, circuit opened")
+		} else {
+			logger.Warn().Err(err).Str("model_provider", p.name).Msg("Model provider failed, trying next provider")
+		}
+		errs = append(errs, fmt.Errorf("model provider %s: %w", p.name, err))
+	}
+
+	if len(errs) == 0 {
+		return ret, ErrNoAvailableModel
+	}
+	return ret, errors.Join(errs...)
+}
+
+// IsTransientError 判断错误是否为暂时性的模型故障，如所有提供方都在熔断、服务端错误、限流与网络错误，稍后重试可能成功
+func IsTransientError(err error) bool {
+	return errors.Is(err, ErrNoAvailableModel) || isRetryableModelError(err)
+}
+
+// isRetryableModelError 判断错误是否应该切换到下一个提供方：5xx、429 限流、超时以及网络连接错误
+func isRetryableModelError(err error) bool {
+	var apiErr *goopenai.APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatusCode(apiErr.HTTPStatusCode)
+	}
+	var reqErr *goopenai.RequestError
+	if errors.As(err, &reqErr) {
+		return isRetryableStatusCode(reqErr.HTTPStatusCode)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return false
+}
+
+func isRetryableStatusCode(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
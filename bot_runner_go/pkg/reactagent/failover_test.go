@@ -0,0 +1,261 @@
+package reactagent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	goopenai "github.com/meguminnnnnnnnn/go-openai"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeChatModel 与真实模型一样自行触发回调
+type fakeChatModel struct {
+	answer    string
+	usage     *schema.TokenUsage
+	err       error
+	streamErr error // 流式输出第一个分片之后返回的错误
+	calls     int
+}
+
+func (m *fakeChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	m.calls++
+	ctx = callbacks.EnsureRunInfo(ctx, "Fake", components.ComponentOfChatModel)
+	ctx = callbacks.OnStart(ctx, &model.CallbackInput{Messages: input})
+	if m.err != nil {
+		callbacks.OnError(ctx, m.err)
+		return nil, m.err
+	}
+	msg := schema.AssistantMessage(m.answer, nil)
+	var usage *model.TokenUsage
+	if m.usage != nil {
+		msg.ResponseMeta = &schema.ResponseMeta{Usage: m.usage}
+		usage = &model.TokenUsage{TotalTokens: m.usage.TotalTokens}
+	}
+	callbacks.OnEnd(ctx, &model.CallbackOutput{Message: msg, TokenUsage: usage})
+	return msg, nil
+}
+
+func (m *fakeChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := m.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sr, sw := schema.Pipe[*schema.Message](2)
+	sw.Send(msg, nil)
+	if m.streamErr != nil {
+		sw.Send(nil, m.streamErr)
+	}
+	sw.Close()
+	return sr, nil
+}
+
+func (m *fakeChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return m, nil
+}
+
+func TestFailoverOnServerError(t *testing.T) {
+	cfg := &CircuitBreakerConfig{FailureThreshold: 2, CoolDown: time.Minute}
+	primary := &fakeChatModel{err: &goopenai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}}
+	backup := &fakeChatModel{answer: "backup"}
+	m := newFailoverModel([]*modelProvider{
+		{name: "primary", model: primary, breaker: newCircuitBreaker(cfg)},
+		{name: "backup", model: backup, breaker: newCircuitBreaker(cfg)},
+	})
+
+	ctx := context.Background()
+	for range 3 {
+		answer, err := m.Generate(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, "backup", answer.Content)
+	}
+
+	// 连续失败两次后主提供方熔断，第三次请求直接跳过
+	require.Equal(t, 2, primary.calls)
+	require.Equal(t, 3, backup.calls)
+	health := m.Health()
+	require.False(t, health[0].Available)
+	require.True(t, health[1].Available)
+}
+
+func TestFailoverCallbacksOnlyForSuccess(t *testing.T) {
+	cfg := &CircuitBreakerConfig{FailureThreshold: 3, CoolDown: time.Minute}
+	primary := &fakeChatModel{err: &goopenai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}}
+	backup := &fakeChatModel{answer: "backup", usage: &schema.TokenUsage{TotalTokens: 10}}
+	m := newFailoverModel([]*modelProvider{
+		{name: "primary", model: primary, breaker: newCircuitBreaker(cfg)},
+		{name: "backup", model: backup, breaker: newCircuitBreaker(cfg)},
+	})
+
+	// 主提供方失败的尝试不触发回调，步数与 token 用量只记录备用提供方的输出
+	collector := newRunCollector(0, func(error) {})
+	ctx := callbacks.InitCallbacks(context.Background(), nil, collector.Handler())
+	_, err := m.Generate(ctx, nil)
+	require.NoError(t, err)
+
+	sr, err := m.Stream(ctx, nil)
+	require.NoError(t, err)
+	for {
+		_, err := sr.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+	}
+	sr.Close()
+	collector.Wait()
+
+	require.Equal(t, 2, primary.calls)
+	require.Equal(t, 2, collector.Steps())
+	require.Equal(t, 20, collector.UsedTokens())
+}
+
+func TestFailoverStreamErrorCountsAsFailure(t *testing.T) {
+	cfg := &CircuitBreakerConfig{FailureThreshold: 1, CoolDown: time.Minute}
+	primary := &fakeChatModel{answer: "partial", streamErr: &goopenai.APIError{HTTPStatusCode: http.StatusBadGateway}}
+	m := newFailoverModel([]*modelProvider{
+		{name: "primary", model: primary, breaker: newCircuitBreaker(cfg)},
+	})
+
+	// 流建立后读取分片出错时计入提供方的失败次数
+	sr, err := m.Stream(context.Background(), nil)
+	require.NoError(t, err)
+	defer sr.Close()
+	chunk, err := sr.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "partial", chunk.Content)
+	_, err = sr.Recv()
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+
+	health := m.Health()[0]
+	require.False(t, health.Available)
+	require.Equal(t, 1, health.ConsecutiveFailures)
+}
+
+func TestFailoverStreamNonRetryableError(t *testing.T) {
+	cfg := &CircuitBreakerConfig{FailureThreshold: 1, CoolDown: time.Minute}
+	primary := &fakeChatModel{answer: "partial", streamErr: &goopenai.APIError{HTTPStatusCode: http.StatusBadRequest}}
+	m := newFailoverModel([]*modelProvider{
+		{name: "primary", model: primary, breaker: newCircuitBreaker(cfg)},
+	})
+
+	// 非可重试错误与调用方取消不计入熔断
+	sr, err := m.Stream(context.Background(), nil)
+	require.NoError(t, err)
+	_, err = sr.Recv()
+	require.NoError(t, err)
+	_, err = sr.Recv()
+	require.Error(t, err)
+	sr.Close()
+
+	primary.streamErr = context.Canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	sr, err = m.Stream(ctx, nil)
+	require.NoError(t, err)
+	cancel()
+	for {
+		if _, err := sr.Recv(); err != nil {
+			break
+		}
+	}
+	sr.Close()
+
+	health := m.Health()[0]
+	require.True(t, health.Available)
+	require.Zero(t, health.ConsecutiveFailures)
+}
+
+func TestConfigDuplicateModelName(t *testing.T) {
+	cfg := &Config{Models: []ModelConfig{{Model: "deepseek-chat"}, {Model: "deepseek-chat"}}}
+	require.ErrorContains(t, cfg.Validate(), "duplicate name")
+
+	// 同一模型的多个提供方以不同的名称区分
+	cfg = &Config{Models: []ModelConfig{{Model: "deepseek-chat"}, {Name: "backup", Model: "deepseek-chat"}}}
+	require.NoError(t, cfg.Validate())
+}
+
+func TestFailoverNonRetryableError(t *testing.T) {
+	cfg := &CircuitBreakerConfig{FailureThreshold: 1, CoolDown: time.Minute}
+	primary := &fakeChatModel{err: &goopenai.APIError{HTTPStatusCode: http.StatusBadRequest}}
+	backup := &fakeChatModel{answer: "backup"}
+	m := newFailoverModel([]*modelProvider{
+		{name: "primary", model: primary, breaker: newCircuitBreaker(cfg)},
+		{name: "backup", model: backup, breaker: newCircuitBreaker(cfg)},
+	})
+
+	// 请求参数错误不会切换提供方，也不会触发熔断
+	_, err := m.Generate(context.Background(), nil)
+	require.Error(t, err)
+	require.Equal(t, 0, backup.calls)
+	require.True(t, m.Health()[0].Available)
+}
+
+func TestCircuitBreakerCoolDown(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{FailureThreshold: 1, CoolDown: time.Minute})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	require.True(t, b.Failure(errors.New("boom")))
+	require.False(t, b.Allow())
+
+	// 冷却结束后只放行一个探测请求，探测失败时重新熔断
+	now = now.Add(time.Minute)
+	require.True(t, b.Health().Available)
+	require.True(t, b.Allow())
+	require.False(t, b.Allow())
+	require.True(t, b.Failure(errors.New("boom")))
+	require.False(t, b.Allow())
+
+	// 探测成功后恢复正常
+	now = now.Add(time.Minute)
+	require.True(t, b.Allow())
+	b.Success()
+	require.True(t, b.Allow())
+	require.True(t, b.Allow())
+	require.Zero(t, b.Health().ConsecutiveFailures)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cfg := &CircuitBreakerConfig{FailureThreshold: 1, CoolDown: time.Minute}
+	primary := &fakeChatModel{err: &goopenai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}}
+	backup := &fakeChatModel{answer: "backup"}
+	breaker := newCircuitBreaker(cfg)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	m := newFailoverModel([]*modelProvider{
+		{name: "primary", model: primary, breaker: breaker},
+		{name: "backup", model: backup, breaker: newCircuitBreaker(cfg)},
+	})
+	ctx := context.Background()
+
+	// 熔断后冷却期内跳过主提供方
+	_, err := m.Generate(ctx, nil)
+	require.NoError(t, err)
+	_, err = m.Generate(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, primary.calls)
+
+	// 冷却结束后探测失败，重新熔断一个冷却期
+	now = now.Add(time.Minute)
+	_, err = m.Generate(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, primary.calls)
+	require.Equal(t, now.Add(time.Minute), m.Health()[0].OpenUntil)
+
+	// 探测成功后恢复使用主提供方
+	now = now.Add(time.Minute)
+	primary.err, primary.answer = nil, "primary"
+	answer, err := m.Generate(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, "primary", answer.Content)
+	require.True(t, m.Health()[0].Available)
+	require.Zero(t, m.Health()[0].OpenUntil)
+}
@@ -0,0 +1,225 @@
+package reactagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	ucb "github.com/cloudwego/eino/utils/callbacks"
+	"github.com/rs/zerolog"
+)
+
+type LimitKind string
+
+const (
+	LimitKindMaxSteps    LimitKind = "max_steps"    // 超出最大步数
+	LimitKindTimeout     LimitKind = "timeout"      // 单次提问超时
+	LimitKindTokenBudget LimitKind = "token_budget" // 超出 token 总预算
+)
+
+// LimitError ReAct 运行触发限制时返回的错误，Partial 为根据目前已有推理得到的回答，可能为空
+type LimitError struct {
+	Kind    LimitKind
+	Partial string
+	Err     error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("react agent exceeded %s limit: %v", e.Kind, e.Err)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	errQuestionTimeout     = errors.New("question timeout")
+	errTokenBudgetExceeded = errors.New("token budget exceeded")
+)
+
+// runCollector 记录一次 ReAct 运行中最近一次模型调用的输入输出与 token 用量，超出 token 预算时取消运行
+type runCollector struct {
+	tokenBudget int
+	cancel      context.CancelCauseFunc
+
+	mu         sync.Mutex
+	lastInput  []*schema.Message
+	lastOutput *schema.Message
+	usedTokens int
+	steps      int // 调用模型的次数
+
+	wg sync.WaitGroup // 读取流式输出的协程
+}
+
+func newRunCollector(tokenBudget int, cancel context.CancelCauseFunc) *runCollector {
+	return &runCollector{tokenBudget: tokenBudget, cancel: cancel}
+}
+
+func (c *runCollector) Handler() callbacks.Handler {
+	return ucb.NewHandlerHelper().ChatModel(&ucb.ModelCallbackHandler{
+		OnStart: func(ctx context.Context, info *callbacks.RunInfo, input *model.CallbackInput) context.Context {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.lastInput = input.Messages
+			c.lastOutput = nil
+			c.steps++
+			return ctx
+		},
+		OnEnd: func(ctx context.Context, info *callbacks.RunInfo, output *model.CallbackOutput) context.Context {
+			c.recordOutput(output)
+			return ctx
+		},
+		OnEndWithStreamOutput: func(ctx context.Context, info *callbacks.RunInfo, output *schema.StreamReader[*model.CallbackOutput]) context.Context {
+			// 回调收到的是流的副本，必须读完并关闭
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				defer output.Close()
+				var msgs []*schema.Message
+				var usage *model.TokenUsage
+				for {
+					chunk, err := output.Recv()
+					if err != nil {
+						break
+					}
+					if chunk.Message != nil {
+						msgs = append(msgs, chunk.Message)
+					}
+					if chunk.TokenUsage != nil {
+						usage = chunk.TokenUsage
+					}
+				}
+				if len(msgs) == 0 {
+					return
+				}
+				msg, err := schema.ConcatMessages(msgs)
+				if err != nil {
+					zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to concat streamed model output")
+					return
+				}
+				c.recordOutput(&model.CallbackOutput{Message: msg, TokenUsage: usage})
+			}()
+			return ctx
+		},
+	}).Handler()
+}
+
+func (c *runCollector) recordOutput(output *model.CallbackOutput) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastOutput = output.Message
+	if output.TokenUsage != nil {
+		c.usedTokens += output.TokenUsage.TotalTokens
+	}
+	if c.tokenBudget > 0 && c.usedTokens > c.tokenBudget {
+		c.cancel(errTokenBudgetExceeded)
+	}
+}
+
+// Wait 等待流式输出读取并记录完毕，读取步数、token 用量与最近一次输出之前调用
+func (c *runCollector) Wait() {
+	c.wg.Wait()
+}
+
+func (c *runCollector) UsedTokens() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usedTokens
+}
+
+func (c *runCollector) Steps() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.steps
+}
+
+// snapshot 返回最近一次模型调用的输入，以及不含工具调用的最近一次输出内容
+func (c *runCollector) snapshot() ([]*schema.Message, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var content string
+	if c.lastOutput != nil && len(c.lastOutput.ToolCalls) == 0 {
+		content = c.lastOutput.Content
+	}
+	return c.lastInput, content
+}
+
+// limitKind 判断运行错误是否由限制触发，调用方自身取消时不属于限制
+func limitKind(runCtx context.Context, err error) (LimitKind, bool) {
+	if errors.Is(err, compose.ErrExceedMaxSteps) {
+		return LimitKindMaxSteps, true
+	}
+	switch context.Cause(runCtx) {
+	case errQuestionTimeout:
+		return LimitKindTimeout, true
+	case errTokenBudgetExceeded:
+		return LimitKindTokenBudget, true
+	}
+	return "", false
+}
+
+// partialAnswer 触发限制后根据目前的推理给出回答：已有不含工具调用的回答时直接使用，
+// 否则在未超出 token 预算时，禁止调用工具并让模型根据已获得的信息直接作答
+func (r *ReactAgent) partialAnswer(ctx context.Context, kind LimitKind, collector *runCollector) string {
+	logger := zerolog.Ctx(ctx)
+	input, content := collector.snapshot()
+	if content != "" || kind == LimitKindTokenBudget || len(input) == 0 {
+		return content
+	}
+
+	finalCtx, cancel := context.WithTimeout(ctx, r.limits.FinalAnswerTimeout)
+	defer cancel()
+	msgs := append(append([]*schema.Message(nil), input...),
+		schema.UserMessage("已达到本次处理的推理上限，请不要再调用任何工具，根据目前已获得的信息直接给出最终回答。"))
+	answer, err := r.chatModel.Generate(finalCtx, msgs)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate final answer after limit exceeded")
+		return ""
+	}
+	return answer.Content
+}
+
+var _ tool.InvokableTool = (*timeoutTool)(nil)
+
+// timeoutTool 限制单次工具调用的执行时间，超时时把超时信息作为工具结果返回给模型
+type timeoutTool struct {
+	tool.InvokableTool
+	timeout time.Duration
+}
+
+func (t *timeoutTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	toolCtx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	ret, err := t.InvokableTool.InvokableRun(toolCtx, argumentsInJSON, opts...)
+	if err != nil && ctx.Err() == nil && errors.Is(toolCtx.Err(), context.DeadlineExceeded) {
+		info, infoErr := t.Info(ctx)
+		if infoErr != nil {
+			return "", err
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).Str("tool", info.Name).Msg("Tool call timed out")
+		return fmt.Sprintf("工具 %s 执行超时（%s），请不要重试该工具", info.Name, t.timeout), nil
+	}
+	return ret, err
+}
+
+// withToolTimeout 为所有可调用工具加上执行超时，未配置超时时原样返回
+func withToolTimeout(tools []tool.BaseTool, timeout time.Duration) []tool.BaseTool {
+	if timeout <= 0 {
+		return tools
+	}
+	ret := make([]tool.BaseTool, 0, len(tools))
+	for _, t := range tools {
+		if invokable, ok := t.(tool.InvokableTool); ok {
+			t = &timeoutTool{InvokableTool: invokable, timeout: timeout}
+		}
+		ret = append(ret, t)
+	}
+	return ret
+}
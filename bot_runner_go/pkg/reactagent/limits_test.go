@@ -0,0 +1,182 @@
+package reactagent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/components/tool/utils"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/require"
+)
+
+// loopingChatModel 总是要求调用 current_time 工具，收到推理上限提示时才给出回答，
+// 与真实模型一样在 ResponseMeta 中上报 token 用量
+type loopingChatModel struct {
+	tokensPerCall int
+}
+
+func (m *loopingChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	msg := schema.AssistantMessage("", []schema.ToolCall{{
+		ID:       "call",
+		Function: schema.FunctionCall{Name: "current_time", Arguments: "{}"},
+	}})
+	if last := input[len(input)-1]; last.Role == schema.User && strings.Contains(last.Content, "推理上限") {
+		msg = schema.AssistantMessage("部分回答", nil)
+	}
+	msg.ResponseMeta = &schema.ResponseMeta{Usage: &schema.TokenUsage{TotalTokens: m.tokensPerCall}}
+	return msg, nil
+}
+
+func (m *loopingChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := m.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+func (m *loopingChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return m, nil
+}
+
+// slowChatModel 一直等待到调用方超时，收到推理上限提示时才给出回答
+type slowChatModel struct{}
+
+func (m *slowChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	if last := input[len(input)-1]; last.Role == schema.User && strings.Contains(last.Content, "推理上限") {
+		return schema.AssistantMessage("部分回答", nil), nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (m *slowChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := m.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+func (m *slowChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return m, nil
+}
+
+func newLimitedAgent(t *testing.T, limits LimitsConfig, chatModel model.ToolCallingChatModel) *ReactAgent {
+	require.NoError(t, limits.Validate())
+	currentTime, err := newCurrentTimeTool(&BuiltinToolDeps{})
+	require.NoError(t, err)
+	r := &ReactAgent{
+		chatModel: newFailoverModel([]*modelProvider{
+			{name: "looping", model: chatModel, breaker: newCircuitBreaker(&CircuitBreakerConfig{FailureThreshold: 3, CoolDown: time.Minute})},
+		}),
+		builtinTools: []tool.BaseTool{currentTime},
+		authorizer:   &toolAuthorizer{},
+		limits:       limits,
+		mcp:          newMCPManager(context.Background(), nil, &MCPReconnectConfig{}),
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestMaxStepsAnswersFromPartialReasoning(t *testing.T) {
+	r := newLimitedAgent(t, LimitsConfig{MaxSteps: 4}, &loopingChatModel{})
+
+	answer, err := r.Question(context.Background(), "group", "现在几点")
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitKindMaxSteps, limitErr.Kind)
+	require.Equal(t, "部分回答", limitErr.Partial)
+	require.Equal(t, "部分回答", answer)
+}
+
+func TestMaxStepsStreamsPartialAnswer(t *testing.T) {
+	r := newLimitedAgent(t, LimitsConfig{MaxSteps: 4}, &loopingChatModel{})
+
+	var deltas []string
+	_, err := r.QuestionStream(context.Background(), "group", "现在几点", func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, []string{"部分回答"}, deltas)
+}
+
+func TestTokenBudgetExceeded(t *testing.T) {
+	r := newLimitedAgent(t, LimitsConfig{MaxSteps: 20, TokenBudget: 250}, &loopingChatModel{tokensPerCall: 100})
+
+	// 超出 token 预算后不再额外调用模型，最近一次输出是工具调用，因此没有部分回答
+	_, err := r.Question(context.Background(), "group", "现在几点")
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitKindTokenBudget, limitErr.Kind)
+	require.Empty(t, limitErr.Partial)
+}
+
+func TestLimitsWithoutTools(t *testing.T) {
+	// 没有任何可用工具时直接调用模型，运行限制同样生效
+	r := newLimitedAgent(t, LimitsConfig{Timeout: 50 * time.Millisecond, FinalAnswerTimeout: time.Second}, &slowChatModel{})
+	r.builtinTools = nil
+
+	answer, err := r.Question(context.Background(), "group", "现在几点")
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitKindTimeout, limitErr.Kind)
+	require.Equal(t, "部分回答", answer)
+
+	var deltas []string
+	_, err = r.QuestionStream(context.Background(), "group", "现在几点", func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, []string{"部分回答"}, deltas)
+
+	// 直接调用模型时同样记录 token 用量
+	r = newLimitedAgent(t, LimitsConfig{TokenBudget: 50}, &fakeChatModel{answer: "回答", usage: &schema.TokenUsage{TotalTokens: 100}})
+	r.builtinTools = nil
+	collector := newRunCollector(r.limits.TokenBudget, func(error) {})
+	_, err = r.generate(context.Background(), nil, nil, collector)
+	require.NoError(t, err)
+	require.Equal(t, 1, collector.Steps())
+	require.Equal(t, 100, collector.UsedTokens())
+}
+
+func TestToolTimeout(t *testing.T) {
+	slow, err := utils.InferTool("slow", "slow tool", func(ctx context.Context, input struct{}) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	require.NoError(t, err)
+
+	tools := withToolTimeout([]tool.BaseTool{slow}, 10*time.Millisecond)
+	out, err := tools[0].(tool.InvokableTool).InvokableRun(context.Background(), "{}")
+	require.NoError(t, err)
+	require.Contains(t, out, "执行超时")
+}
+
+func TestRunCollectorWaitsForStreamOutput(t *testing.T) {
+	collector := newRunCollector(0, func(error) {})
+	ctx := callbacks.InitCallbacks(context.Background(), &callbacks.RunInfo{Component: components.ComponentOfChatModel}, collector.Handler())
+
+	// 流式输出在回调中异步读取，Wait 返回后 token 用量与最近一次输出已记录
+	sr, sw := schema.Pipe[*model.CallbackOutput](1)
+	callbacks.OnEndWithStreamOutput(ctx, sr)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		sw.Send(&model.CallbackOutput{Message: schema.AssistantMessage("回答", nil), TokenUsage: &model.TokenUsage{TotalTokens: 10}}, nil)
+		sw.Close()
+	}()
+	collector.Wait()
+	require.Equal(t, 10, collector.UsedTokens())
+	_, content := collector.snapshot()
+	require.Equal(t, "回答", content)
+}
@@ -0,0 +1,316 @@
+package reactagent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	einomcp "github.com/cloudwego/eino-ext/components/tool/mcp"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/rs/zerolog"
+)
+
+// MCPServerStatus MCP 服务器的连接状态
+type MCPServerStatus struct {
+	Name        string    `json:"name"`                  // MCP 服务器名称
+	Transport   string    `json:"transport"`             // 传输方式
+	Connected   bool      `json:"connected"`             // 是否已连接
+	Tools       []string  `json:"tools"`                 // 已加载的工具名称
+	LastError   string    `json:"last_error,omitempty"`  // 最近一次连接失败原因
+	ConnectedAt time.Time `json:"connected_at,omitzero"` // 最近一次连接成功的时间
+}
+
+// mcpManager 管理所有 MCP 服务器的连接，每个服务器独立连接、断线重连，
+// 不可用的服务器不会出现在工具集中，工具集变化时递增版本号
+type mcpManager struct {
+	servers []*mcpServerConn
+	version atomic.Uint64
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newMCPManager(ctx context.Context, servers []MCPServer, reconnectCfg *MCPReconnectConfig) *mcpManager {
+	ctx, cancel := context.WithCancel(ctx)
+	m := &mcpManager{cancel: cancel}
+	for i := range servers {
+		conn := &mcpServerConn{
+			cfg:          &servers[i],
+			reconnectCfg: reconnectCfg,
+			onChange:     func() { m.version.Add(1) },
+			refreshCh:    make(chan struct{}, 1),
+		}
+		m.servers = append(m.servers, conn)
+
+		logger := zerolog.Ctx(ctx).With().Str("mcp_server", conn.cfg.Name).
+			Str("mcp_transport", string(conn.cfg.Transport)).Logger()
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			conn.run(logger.WithContext(ctx))
+		}()
+	}
+	return m
+}
+
+// Tools 返回当前所有可用服务器的工具以及工具集版本号
+func (m *mcpManager) Tools() ([]tool.BaseTool, uint64) {
+	version := m.version.Load()
+	var tools []tool.BaseTool
+	for _, conn := range m.servers {
+		conn.mu.RLock()
+		tools = append(tools, conn.tools...)
+		conn.mu.RUnlock()
+	}
+	return tools, version
+}
+
+func (m *mcpManager) Status() []MCPServerStatus {
+	ret := make([]MCPServerStatus, 0, len(m.servers))
+	for _, conn := range m.servers {
+		ret = append(ret, conn.status())
+	}
+	return ret
+}
+
+// Close 停止所有重连协程并关闭 MCP 客户端
+func (m *mcpManager) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+type mcpServerConn struct {
+	cfg          *MCPServer
+	reconnectCfg *MCPReconnectConfig
+	onChange     func()        // 工具集变化时回调
+	refreshCh    chan struct{} // 收到工具列表变化通知时触发刷新
+
+	mu          sync.RWMutex
+	cli         *client.Client
+	tools       []tool.BaseTool
+	toolNames   []string
+	lastErr     error
+	connectedAt time.Time
+}
+
+// run 循环连接 MCP 服务器，失败时按指数退避重试，连接成功后定期探活，直到 ctx 取消
+func (c *mcpServerConn) run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+	backoff := c.reconnectCfg.InitialBackoff
+	for {
+		start := time.Now()
+		err := c.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		c.setDisconnected(err)
+		logger.Error().Err(err).Dur("backoff", backoff).Msg("MCP server unavailable, will reconnect")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		// 本次曾经连接成功，则重新从最小退避时间开始
+		if c.connectedAfter(start) {
+			backoff = c.reconnectCfg.InitialBackoff
+		} else {
+			backoff = min(backoff*2, c.reconnectCfg.MaxBackoff)
+		}
+	}
+}
+
+// serve 建立一次连接并保持，直到连接失效或 ctx 取消
+func (c *mcpServerConn) serve(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+
+	cli, err := connectMCPServer(ctx, c.cfg, c.reconnectCfg.ConnectTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := cli.Close(); err != nil {
+			logger.Error().Err(err).Msg("failed to close MCP client")
+		}
+	}()
+
+	// 服务器通知工具列表变化时刷新工具
+	cli.OnNotification(func(notification mcp.JSONRPCNotification) {
+		if notification.Method == mcp.MethodNotificationToolsListChanged {
+			select {
+			case c.refreshCh <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err := c.refreshTools(ctx, cli); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(c.reconnectCfg.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.setDisconnected(nil)
+			return nil
+		case <-c.refreshCh:
+			logger.Info().Msg("MCP server tools list changed, refreshing")
+			if err := c.refreshTools(ctx, cli); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, c.reconnectCfg.ConnectTimeout)
+			err := cli.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("ping MCP server failed: %w", err)
+			}
+		}
+	}
+}
+
+func (c *mcpServerConn) refreshTools(ctx context.Context, cli *client.Client) error {
+	logger := zerolog.Ctx(ctx)
+
+	listCtx, cancel := context.WithTimeout(ctx, c.reconnectCfg.ConnectTimeout)
+	defer cancel()
+	tools, err := einomcp.GetTools(listCtx, &einomcp.Config{
+		Cli:          cli,
+		ToolNameList: c.cfg.ToolNameList,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get MCP tools: %w", err)
+	}
+	names := make([]string, 0, len(tools))
+	for _, t := range tools {
+		info, err := t.Info(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get MCP tool info: %w", err)
+		}
+		names = append(names, info.Name)
+	}
+
+	c.mu.Lock()
+	if c.cli != cli {
+		c.connectedAt = time.Now()
+	}
+	c.cli = cli
+	c.tools = tools
+	c.toolNames = names
+	c.lastErr = nil
+	c.mu.Unlock()
+	c.onChange()
+
+	logger.Info().Strs("tools", names).Msg("MCP server tools loaded")
+	return nil
+}
+
+func (c *mcpServerConn) setDisconnected(err error) {
+	c.mu.Lock()
+	changed := c.cli != nil
+	c.cli = nil
+	c.tools = nil
+	c.toolNames = nil
+	c.lastErr = err
+	c.mu.Unlock()
+	if changed {
+		c.onChange()
+	}
+}
+
+func (c *mcpServerConn) connectedAfter(t time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connectedAt.After(t)
+}
+
+func (c *mcpServerConn) status() MCPServerStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := MCPServerStatus{
+		Name:        c.cfg.Name,
+		Transport:   string(c.cfg.Transport),
+		Connected:   c.cli != nil,
+		Tools:       append([]string(nil), c.toolNames...),
+		ConnectedAt: c.connectedAt,
+	}
+	if c.lastErr != nil {
+		s.LastError = c.lastErr.Error()
+	}
+	return s
+}
+
+// connectMCPServer 根据传输方式创建并启动 MCP 客户端，完成初始化握手。
+// 传输层的生命周期与 ctx 绑定，初始化握手受 timeout 限制
+func connectMCPServer(ctx context.Context, cfg *MCPServer, timeout time.Duration) (*client.Client, error) {
+	logger := zerolog.Ctx(ctx)
+
+	tp, err := newMCPTransport(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s transport: %w", cfg.Transport, err)
+	}
+	cli := client.NewClient(tp)
+	if err := cli.Start(ctx); err != nil {
+		// 启动失败时同样关闭客户端，释放传输层已占用的管道与连接
+		if closeErr := cli.Close(); closeErr != nil {
+			logger.Debug().Err(closeErr).Msg("failed to close MCP client")
+		}
+		return nil, fmt.Errorf("failed to start %s transport: %w", cfg.Transport, err)
+	}
+
+	// stdio 子进程的标准错误输出转发到日志，同时避免管道写满阻塞子进程
+	if stdio, ok := tp.(*transport.Stdio); ok {
+		go func() {
+			scanner := bufio.NewScanner(stdio.Stderr())
+			for scanner.Scan() {
+				logger.Debug().Str("stderr", scanner.Text()).Msg("MCP server stderr")
+			}
+		}()
+	}
+
+	// 初始化MCP请求
+	initRequest := mcp.InitializeRequest{
+		Params: mcp.InitializeParams{
+			ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
+			ClientInfo: mcp.Implementation{
+				Name:    cfg.Name,
+				Version: cfg.Version,
+			},
+		},
+	}
+	initCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, err := cli.Initialize(initCtx, initRequest); err != nil {
+		if closeErr := cli.Close(); closeErr != nil {
+			logger.Error().Err(closeErr).Msg("failed to close MCP client")
+		}
+		return nil, fmt.Errorf("failed to initialize MCP client: %w", err)
+	}
+	return cli, nil
+}
+
+func newMCPTransport(cfg *MCPServer) (transport.Interface, error) {
+	switch cfg.Transport {
+	case MCPTransportSSE:
+		return transport.NewSSE(cfg.BaseURL, transport.WithHeaders(cfg.Headers))
+	case MCPTransportStreamableHTTP:
+		return transport.NewStreamableHTTP(cfg.BaseURL, transport.WithHTTPHeaders(cfg.Headers))
+	case MCPTransportStdio:
+		// 环境变量追加在当前进程的环境变量之后，按名称排序保证顺序稳定
+		env := make([]string, 0, len(cfg.Env))
+		for k, v := range cfg.Env {
+			env = append(env, k+"="+v)
+		}
+		sort.Strings(env)
+		return transport.NewStdio(cfg.Command, env, cfg.Args...), nil
+	default:
+		return nil, fmt.Errorf("unknown MCP transport: %s", cfg.Transport)
+	}
+}
@@ -0,0 +1,120 @@
+package reactagent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMCPManagerReconnect(t *testing.T) {
+	// 预留一个端口，MCP 服务器稍后才在该端口启动
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.DebugLevel)
+	ctx := logger.WithContext(context.Background())
+	m := newMCPManager(ctx, []MCPServer{{
+		Name:      "echo",
+		Transport: MCPTransportStreamableHTTP,
+		BaseURL:   "http://" + addr + "/mcp",
+	}}, &MCPReconnectConfig{
+		InitialBackoff:      50 * time.Millisecond,
+		MaxBackoff:          200 * time.Millisecond,
+		ConnectTimeout:      time.Second,
+		HealthCheckInterval: 100 * time.Millisecond,
+	})
+	defer m.Close()
+
+	// 服务器未启动时没有可用工具
+	tools, _ := m.Tools()
+	require.Empty(t, tools)
+
+	mcpServer := server.NewMCPServer("echo", "1.0.0", server.WithToolCapabilities(true))
+	mcpServer.AddTool(mcp.NewTool("echo"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("echo"), nil
+	})
+	httpServer := &http.Server{Addr: addr, Handler: server.NewStreamableHTTPServer(mcpServer)}
+	go httpServer.ListenAndServe()
+
+	// 服务器启动后自动重连并加载工具
+	require.Eventually(t, func() bool {
+		tools, _ := m.Tools()
+		return len(tools) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	status := m.Status()
+	require.True(t, status[0].Connected)
+	require.Equal(t, []string{"echo"}, status[0].Tools)
+
+	// 服务器停止后探活失败，工具从工具集中移除
+	require.NoError(t, httpServer.Close())
+	require.Eventually(t, func() bool {
+		tools, _ := m.Tools()
+		return len(tools) == 0
+	}, 5*time.Second, 20*time.Millisecond)
+	require.False(t, m.Status()[0].Connected)
+}
+
+func TestMCPManagerToolsListChanged(t *testing.T) {
+	mcpServer := server.NewMCPServer("echo", "1.0.0", server.WithToolCapabilities(true))
+	mcpServer.AddTool(mcp.NewTool("echo"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("echo"), nil
+	})
+	sseServer := server.NewTestServer(mcpServer)
+	defer sseServer.Close()
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.DebugLevel)
+	ctx := logger.WithContext(context.Background())
+	m := newMCPManager(ctx, []MCPServer{{
+		Name:      "echo",
+		Transport: MCPTransportSSE,
+		BaseURL:   sseServer.URL + "/sse",
+	}}, &MCPReconnectConfig{
+		InitialBackoff:      50 * time.Millisecond,
+		MaxBackoff:          200 * time.Millisecond,
+		ConnectTimeout:      time.Second,
+		HealthCheckInterval: time.Minute,
+	})
+	defer m.Close()
+
+	var version uint64
+	require.Eventually(t, func() bool {
+		var tools []tool.BaseTool
+		tools, version = m.Tools()
+		return len(tools) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// 服务器新增工具并通知工具列表变化后，刷新工具集并提升版本号
+	mcpServer.AddTool(mcp.NewTool("reverse"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("esrever"), nil
+	})
+	require.Eventually(t, func() bool {
+		tools, v := m.Tools()
+		return len(tools) == 2 && v > version
+	}, 5*time.Second, 20*time.Millisecond)
+	require.ElementsMatch(t, []string{"echo", "reverse"}, m.Status()[0].Tools)
+}
+
+func TestConnectMCPServerStartFailed(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.DebugLevel)
+	ctx := logger.WithContext(context.Background())
+
+	// 命令无法启动时关闭客户端并返回错误
+	for range 3 {
+		_, err := connectMCPServer(ctx, &MCPServer{
+			Name:      "missing",
+			Transport: MCPTransportStdio,
+			Command:   "/nonexistent/mcp-server",
+		}, time.Second)
+		require.ErrorContains(t, err, "failed to start stdio transport")
+	}
+}
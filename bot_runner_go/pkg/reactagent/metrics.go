@@ -0,0 +1,126 @@
+package reactagent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// llmCalls 调用模型提供方的次数，result 为 success/error，熔断中跳过的提供方不计入
+	llmCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "agent",
+		Name:      "llm_calls_total",
+		Help:      "Number of chat model calls, by provider and result.",
+	}, []string{"provider", "result"})
+
+	// llmTokens 模型上报的 token 用量，type 为 input/output
+	llmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "agent",
+		Name:      "llm_tokens_total",
+		Help:      "Number of tokens reported by the chat model, by provider and type (input, output).",
+	}, []string{"provider", "type"})
+
+	// toolCalls 工具调用次数，result 为 success/error
+	toolCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "agent",
+		Name:      "tool_calls_total",
+		Help:      "Number of tool calls, by tool name and result.",
+	}, []string{"tool", "result"})
+
+	// toolDuration 工具调用的耗时
+	toolDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "agent",
+		Name:      "tool_call_duration_seconds",
+		Help:      "Time spent in tool calls, by tool name.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"tool"})
+
+	// questions 处理的问题数，result 为 success/error 或触发的限制
+	questions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "agent",
+		Name:      "questions_total",
+		Help:      "Number of questions processed, by result (success, error, max_steps, timeout, token_budget).",
+	}, []string{"result"})
+
+	// questionSteps 每个问题调用模型的次数，即 ReAct 的推理步数
+	questionSteps = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "sugar_bot",
+		Subsystem: "agent",
+		Name:      "question_steps",
+		Help:      "Number of chat model calls (ReAct steps) per question.",
+		Buckets:   []float64{1, 2, 3, 4, 6, 8, 12, 16, 24, 32},
+	})
+)
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// recordQuestion 记录一个问题的处理结果与推理步数
+func recordQuestion(steps int, err error) {
+	result := resultLabel(err)
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		result = string(limitErr.Kind)
+	}
+	questions.WithLabelValues(result).Inc()
+	if steps > 0 {
+		questionSteps.Observe(float64(steps))
+	}
+}
+
+// recordTokenUsage 记录模型返回的 token 用量，流式输出时用量通常只出现在最后一个分片
+func recordTokenUsage(provider string, msg *schema.Message) {
+	if msg == nil || msg.ResponseMeta == nil || msg.ResponseMeta.Usage == nil {
+		return
+	}
+	usage := msg.ResponseMeta.Usage
+	llmTokens.WithLabelValues(provider, "input").Add(float64(usage.PromptTokens))
+	llmTokens.WithLabelValues(provider, "output").Add(float64(usage.CompletionTokens))
+}
+
+var _ tool.InvokableTool = (*metricsTool)(nil)
+
+// metricsTool 记录工具的调用次数、结果与耗时
+type metricsTool struct {
+	tool.InvokableTool
+	name string
+}
+
+func (t *metricsTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	started := time.Now()
+	ret, err := t.InvokableTool.InvokableRun(ctx, argumentsInJSON, opts...)
+	toolDuration.WithLabelValues(t.name).Observe(time.Since(started).Seconds())
+	toolCalls.WithLabelValues(t.name, resultLabel(err)).Inc()
+	return ret, err
+}
+
+// withToolMetrics 为所有可调用工具记录调用指标
+func withToolMetrics(ctx context.Context, tools []tool.BaseTool) ([]tool.BaseTool, error) {
+	ret := make([]tool.BaseTool, 0, len(tools))
+	for _, t := range tools {
+		if invokable, ok := t.(tool.InvokableTool); ok {
+			info, err := t.Info(ctx)
+			if err != nil {
+				return nil, err
+			}
+			t = &metricsTool{InvokableTool: invokable, name: info.Name}
+		}
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
@@ -0,0 +1,87 @@
+package reactagent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/components/tool/utils"
+	"github.com/cloudwego/eino/schema"
+	goopenai "github.com/meguminnnnnnnnn/go-openai"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// counter 返回计数器的当前值，指标为全局变量，断言时比较前后的差值
+func counter(c *prometheus.CounterVec, labels ...string) float64 {
+	return testutil.ToFloat64(c.WithLabelValues(labels...))
+}
+
+func TestLLMMetrics(t *testing.T) {
+	failed := counter(llmCalls, "metrics-primary", "error")
+	succeeded := counter(llmCalls, "metrics-backup", "success")
+	input := counter(llmTokens, "metrics-backup", "input")
+	output := counter(llmTokens, "metrics-backup", "output")
+	cfg := &CircuitBreakerConfig{FailureThreshold: 3, CoolDown: time.Minute}
+	m := newFailoverModel([]*modelProvider{
+		{name: "metrics-primary", model: &fakeChatModel{err: &goopenai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}}, breaker: newCircuitBreaker(cfg)},
+		{name: "metrics-backup", model: &fakeChatModel{answer: "ok", usage: &schema.TokenUsage{PromptTokens: 30, CompletionTokens: 5}}, breaker: newCircuitBreaker(cfg)},
+	})
+
+	_, err := m.Generate(context.Background(), nil)
+	require.NoError(t, err)
+	// 流式输出在读取分片时记录 token 用量
+	sr, err := m.Stream(context.Background(), nil)
+	require.NoError(t, err)
+	for {
+		if _, err := sr.Recv(); errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	sr.Close()
+
+	require.Equal(t, failed+2, counter(llmCalls, "metrics-primary", "error"))
+	require.Equal(t, succeeded+2, counter(llmCalls, "metrics-backup", "success"))
+	require.Equal(t, input+60, counter(llmTokens, "metrics-backup", "input"))
+	require.Equal(t, output+10, counter(llmTokens, "metrics-backup", "output"))
+}
+
+func TestToolMetrics(t *testing.T) {
+	echo, err := utils.InferTool("metrics_echo", "echo tool", func(ctx context.Context, input struct{ Fail bool }) (string, error) {
+		if input.Fail {
+			return "", errors.New("failed")
+		}
+		return "ok", nil
+	})
+	require.NoError(t, err)
+	succeeded := counter(toolCalls, "metrics_echo", "success")
+	failed := counter(toolCalls, "metrics_echo", "error")
+
+	tools, err := withToolMetrics(context.Background(), []tool.BaseTool{echo})
+	require.NoError(t, err)
+	invokable := tools[0].(tool.InvokableTool)
+	_, err = invokable.InvokableRun(context.Background(), `{"Fail": false}`)
+	require.NoError(t, err)
+	_, err = invokable.InvokableRun(context.Background(), `{"Fail": true}`)
+	require.Error(t, err)
+
+	require.Equal(t, succeeded+1, counter(toolCalls, "metrics_echo", "success"))
+	require.Equal(t, failed+1, counter(toolCalls, "metrics_echo", "error"))
+}
+
+func TestQuestionMetrics(t *testing.T) {
+	maxSteps := counter(questions, string(LimitKindMaxSteps))
+	toolSuccess := counter(toolCalls, "current_time", "success")
+	r := newLimitedAgent(t, LimitsConfig{MaxSteps: 4}, &loopingChatModel{})
+
+	_, err := r.Question(context.Background(), "group", "现在几点")
+	require.Error(t, err)
+	require.Equal(t, maxSteps+1, counter(questions, string(LimitKindMaxSteps)))
+	// 最大 4 步时模型与工具各调用 2 次，随后根据已有推理作答
+	require.Equal(t, toolSuccess+2, counter(toolCalls, "current_time", "success"))
+}
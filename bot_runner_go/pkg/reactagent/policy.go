@@ -0,0 +1,175 @@
+package reactagent
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/rs/zerolog"
+)
+
+// Caller 发起本次提问的用户身份，工具授权策略依据它判断工具能否执行
+type Caller struct {
+	Sender       string // 发送者
+	SenderRemark string // 发送者备注
+	ChatName     string // 会话名称
+	MessageID    string // 所提问消息的 ID，重新处理同一条消息时不重复记录会话历史
+}
+
+type ctxKeyCaller struct{}
+
+// WithCaller 在 context 中记录提问者身份
+func WithCaller(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, ctxKeyCaller{}, caller)
+}
+
+// CallerFromContext 获取提问者身份，未记录时返回空身份
+func CallerFromContext(ctx context.Context) *Caller {
+	if v, ok := ctx.Value(ctxKeyCaller{}).(*Caller); ok {
+		return v
+	}
+	return &Caller{}
+}
+
+// toolPolicyEnv 授权规则的 expr 执行环境
+type toolPolicyEnv struct {
+	Tool         string // 工具名称
+	Sender       string // 发送者
+	SenderRemark string // 发送者备注
+	ChatName     string // 会话名称
+}
+
+type toolPolicy struct {
+	cfg  *ToolPolicyConfig
+	rule *vm.Program
+}
+
+// toolAuthorizer 按配置顺序匹配工具授权策略，工具命中的所有策略都允许时才可执行，未命中任何策略的工具默认允许
+type toolAuthorizer struct {
+	policies []*toolPolicy
+}
+
+func newToolAuthorizer(cfgs []ToolPolicyConfig) (*toolAuthorizer, error) {
+	a := &toolAuthorizer{}
+	for i := range cfgs {
+		cfg := &cfgs[i]
+		for _, pattern := range cfg.Tools {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("tool_policies[%d]: invalid tool pattern %q: %w", i, pattern, err)
+			}
+		}
+		p := &toolPolicy{cfg: cfg}
+		if cfg.Rule != "" {
+			program, err := expr.Compile(cfg.Rule, expr.Env(toolPolicyEnv{}), expr.AsBool())
+			if err != nil {
+				return nil, fmt.Errorf("tool_policies[%d]: failed to compile rule: %w", i, err)
+			}
+			p.rule = program
+		}
+		a.policies = append(a.policies, p)
+	}
+	return a, nil
+}
+
+// Authorize 判断提问者能否执行工具，拒绝时返回原因
+func (a *toolAuthorizer) Authorize(toolName string, caller *Caller) (bool, string) {
+	for _, p := range a.policies {
+		if !p.matchTool(toolName) {
+			continue
+		}
+		if allowed, reason := p.authorize(toolName, caller); !allowed {
+			return false, reason
+		}
+	}
+	return true, ""
+}
+
+func (p *toolPolicy) matchTool(toolName string) bool {
+	for _, pattern := range p.cfg.Tools {
+		if ok, _ := path.Match(pattern, toolName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *toolPolicy) authorize(toolName string, caller *Caller) (bool, string) {
+	// 发送者同时匹配微信名与备注
+	matchSender := func(list []string) bool {
+		return slices.Contains(list, caller.Sender) ||
+			(caller.SenderRemark != "" && slices.Contains(list, caller.SenderRemark))
+	}
+
+	if matchSender(p.cfg.DenySenders) {
+		return false, "sender is in deny list"
+	}
+	if slices.Contains(p.cfg.DenyChats, caller.ChatName) {
+		return false, "chat is in deny list"
+	}
+	if len(p.cfg.AllowSenders) > 0 && !matchSender(p.cfg.AllowSenders) {
+		return false, "sender is not in allow list"
+	}
+	if len(p.cfg.AllowChats) > 0 && !slices.Contains(p.cfg.AllowChats, caller.ChatName) {
+		return false, "chat is not in allow list"
+	}
+	if p.rule != nil {
+		ret, err := expr.Run(p.rule, toolPolicyEnv{
+			Tool:         toolName,
+			Sender:       caller.Sender,
+			SenderRemark: caller.SenderRemark,
+			ChatName:     caller.ChatName,
+		})
+		if err != nil {
+			return false, "failed to run rule: " + err.Error()
+		}
+		if allowed, _ := ret.(bool); !allowed {
+			return false, "rule rejected"
+		}
+	}
+	return true, ""
+}
+
+var _ tool.InvokableTool = (*guardedTool)(nil)
+
+// guardedTool 在工具执行前进行授权检查，拒绝时把拒绝原因作为工具结果返回给模型
+type guardedTool struct {
+	tool.InvokableTool
+	authorizer *toolAuthorizer
+}
+
+func (t *guardedTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	info, err := t.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+	caller := CallerFromContext(ctx)
+	if allowed, reason := t.authorizer.Authorize(info.Name, caller); !allowed {
+		zerolog.Ctx(ctx).Warn().
+			Str("tool", info.Name).
+			Str("sender", caller.Sender).
+			Str("chat_name", caller.ChatName).
+			Str("reason", reason).
+			Msg("Tool call denied by policy")
+		return fmt.Sprintf("拒绝执行工具 %s：当前用户没有权限使用该工具，请直接告知用户无法完成此操作", info.Name), nil
+	}
+	return t.InvokableTool.InvokableRun(ctx, argumentsInJSON, opts...)
+}
+
+// guardTools 为所有工具加上授权检查，未配置策略时原样返回。
+// 目前只支持可调用工具，无法检查的流式工具在配置了策略时不会提供给模型
+func (a *toolAuthorizer) guardTools(tools []tool.BaseTool) []tool.BaseTool {
+	if len(a.policies) == 0 {
+		return tools
+	}
+	ret := make([]tool.BaseTool, 0, len(tools))
+	for _, t := range tools {
+		if invokable, ok := t.(tool.InvokableTool); ok {
+			ret = append(ret, &guardedTool{InvokableTool: invokable, authorizer: a})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,59 @@
+package reactagent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolAuthorizer(t *testing.T) {
+	a, err := newToolAuthorizer([]ToolPolicyConfig{
+		{Tools: []string{"send_message"}, AllowSenders: []string{"管理员"}},
+		{Tools: []string{"fs_*"}, DenyChats: []string{"闲聊群"}},
+		{Tools: []string{"fs_write"}, Rule: `ChatName startsWith "运维"`},
+	})
+	require.NoError(t, err)
+
+	// 允许名单同时匹配备注
+	allowed, _ := a.Authorize("send_message", &Caller{Sender: "alice", SenderRemark: "管理员"})
+	require.True(t, allowed)
+	allowed, _ = a.Authorize("send_message", &Caller{Sender: "bob"})
+	require.False(t, allowed)
+
+	// 通配符匹配与拒绝名单
+	allowed, _ = a.Authorize("fs_read", &Caller{ChatName: "闲聊群"})
+	require.False(t, allowed)
+	allowed, _ = a.Authorize("fs_read", &Caller{ChatName: "运维群"})
+	require.True(t, allowed)
+
+	// 命中的所有策略都需要允许
+	allowed, _ = a.Authorize("fs_write", &Caller{ChatName: "开发群"})
+	require.False(t, allowed)
+	allowed, _ = a.Authorize("fs_write", &Caller{ChatName: "运维群"})
+	require.True(t, allowed)
+
+	// 未命中任何策略的工具默认允许
+	allowed, _ = a.Authorize("current_time", &Caller{})
+	require.True(t, allowed)
+}
+
+func TestGuardedToolRefusal(t *testing.T) {
+	a, err := newToolAuthorizer([]ToolPolicyConfig{{Tools: []string{"calculator"}, AllowChats: []string{"数学群"}}})
+	require.NoError(t, err)
+	calc, err := newCalculatorTool(&BuiltinToolDeps{})
+	require.NoError(t, err)
+	guarded := a.guardTools([]tool.BaseTool{calc})[0].(tool.InvokableTool)
+
+	// 被拒绝时返回拒绝信息而不是执行工具
+	ctx := WithCaller(context.Background(), &Caller{ChatName: "闲聊群"})
+	out, err := guarded.InvokableRun(ctx, `{"expression": "1 + 1"}`)
+	require.NoError(t, err)
+	require.Contains(t, out, "拒绝执行工具 calculator")
+
+	ctx = WithCaller(context.Background(), &Caller{ChatName: "数学群"})
+	out, err = guarded.InvokableRun(ctx, `{"expression": "1 + 1"}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"result": 2}`, out)
+}
@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// State runner 的生命周期状态
+type State string
+
+const (
+	StateStarting State = "starting" // 已启动，尚未就绪
+	StateReady    State = "ready"    // 已就绪，正常工作
+	StateDegraded State = "degraded" // 仍在运行，但部分功能不可用
+	StateStopped  State = "stopped"  // 已退出，可能稍后被重启
+)
+
+// HealthChecker 可选接口，runner 实现后由监督者定期检查并发布状态变化，
+// 未实现的 runner 启动后即视为就绪
+type HealthChecker interface {
+	// Ready 是否已完成启动，如 NATS 已连接、MCP 工具已加载、HTTP 已开始监听
+	Ready() bool
+	// Health 就绪后的运行状况，返回错误时视为降级
+	Health() error
+}
+
+// StatusReporter 可选接口，runner 实现后其详细运行状态显示在管理服务的状态页中，
+// 返回值需可以序列化为 JSON
+type StatusReporter interface {
+	Status(ctx context.Context) any
+}
+
+// Event 一次状态变化
+type Event struct {
+	Runner string    `json:"runner"`          // runner 名称
+	State  State     `json:"state"`           // 变化后的状态
+	Error  string    `json:"error,omitempty"` // 降级或出错退出的原因
+	Time   time.Time `json:"time,omitzero"`   // 变化时间
+}
+
+// EventBus 记录每个 runner 的当前状态，并把状态变化广播给订阅者
+type EventBus struct {
+	mu     sync.Mutex
+	states map[string]Event
+	order  []string // runner 首次发布状态的顺序
+	subs   map[chan Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		states: make(map[string]Event),
+		subs:   make(map[chan Event]struct{}),
+	}
+}
+
+// Publish 更新 runner 的状态，状态与原因均未变化时忽略并返回 false。
+// 订阅者的缓冲已满时丢弃该事件，不阻塞发布者，订阅者可通过 States 获取最新状态
+func (b *EventBus) Publish(runner string, state State, err error) bool {
+	e := Event{Runner: runner, State: state, Time: time.Now()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev, ok := b.states[runner]
+	if ok && prev.State == e.State && prev.Error == e.Error {
+		return false
+	}
+	if !ok {
+		b.order = append(b.order, runner)
+	}
+	b.states[runner] = e
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return true
+}
+
+// Subscribe 订阅之后的状态变化，返回的通道最多缓冲 buffer 个事件，调用 cancel 取消订阅并关闭通道
+func (b *EventBus) Subscribe(buffer int) (events <-chan Event, cancel func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// States 返回所有 runner 的当前状态，按首次发布的顺序排列
+func (b *EventBus) States() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ret := make([]Event, 0, len(b.order))
+	for _, name := range b.order {
+		ret = append(ret, b.states[name])
+	}
+	return ret
+}
+
+// State 返回 runner 的当前状态
+func (b *EventBus) State(runner string) (Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.states[runner]
+	return e, ok
+}
+
+// WaitReady 等待 runner 进入就绪状态，用于依赖其他 runner 的 runner 在启动时等待依赖就绪
+func (b *EventBus) WaitReady(ctx context.Context, runner string) error {
+	events, cancel := b.Subscribe(16)
+	defer cancel()
+	if e, ok := b.State(runner); ok && e.State == StateReady {
+		return nil
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-events:
+			if e.Runner == runner && e.State == StateReady {
+				return nil
+			}
+		case <-ticker.C:
+			// 缓冲已满时可能错过事件，定期检查当前状态
+			if e, ok := b.State(runner); ok && e.State == StateReady {
+				return nil
+			}
+		}
+	}
+}
+
+type ctxKeyEventBus struct{}
+
+// WithEventBus 把事件总线放入 ctx，Run 会为所有 runner 设置同一个事件总线
+func WithEventBus(ctx context.Context, bus *EventBus) context.Context {
+	return context.WithValue(ctx, ctxKeyEventBus{}, bus)
+}
+
+// EventBusFromContext 返回 ctx 中的事件总线，没有时返回 nil
+func EventBusFromContext(ctx context.Context) *EventBus {
+	bus, _ := ctx.Value(ctxKeyEventBus{}).(*EventBus)
+	return bus
+}
+
+// errNotReady 曾经就绪的 runner 不再就绪
+var errNotReady = errors.New("runner is no longer ready")
+
+// checkHealth 根据 runner 的就绪与健康状况得出当前状态，wasReady 表示本次运行中是否曾经就绪
+func checkHealth(checker HealthChecker, wasReady bool) (State, error) {
+	if !checker.Ready() {
+		if wasReady {
+			return StateDegraded, errNotReady
+		}
+		return StateStarting, nil
+	}
+	if err := checker.Health(); err != nil {
+		return StateDegraded, err
+	}
+	return StateReady, nil
+}
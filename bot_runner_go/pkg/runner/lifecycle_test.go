@@ -0,0 +1,166 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// checkedRunner 运行到 ctx 取消，就绪与健康状况由测试控制
+type checkedRunner struct {
+	funcRunner
+	ready  atomic.Bool
+	health atomic.Pointer[error]
+}
+
+func (r *checkedRunner) Ready() bool {
+	return r.ready.Load()
+}
+
+func (r *checkedRunner) Health() error {
+	if err := r.health.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (r *checkedRunner) setHealth(err error) {
+	r.health.Store(&err)
+}
+
+// nextEvent 等待下一个状态变化
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(4)
+
+	require.True(t, bus.Publish("NatsServerRunner", StateStarting, nil))
+	require.True(t, bus.Publish("WxAutoRunner", StateStarting, nil))
+	require.True(t, bus.Publish("NatsServerRunner", StateReady, nil))
+	// 状态与原因均未变化时不重复发布
+	require.False(t, bus.Publish("NatsServerRunner", StateReady, nil))
+	require.True(t, bus.Publish("WxAutoRunner", StateDegraded, errors.New("nats disconnected")))
+
+	require.Equal(t, StateStarting, nextEvent(t, events).State)
+	require.Equal(t, "WxAutoRunner", nextEvent(t, events).Runner)
+	require.Equal(t, StateReady, nextEvent(t, events).State)
+	e := nextEvent(t, events)
+	require.Equal(t, StateDegraded, e.State)
+	require.Equal(t, "nats disconnected", e.Error)
+
+	states := bus.States()
+	require.Len(t, states, 2)
+	require.Equal(t, "NatsServerRunner", states[0].Runner)
+	require.Equal(t, StateReady, states[0].State)
+	require.Equal(t, StateDegraded, states[1].State)
+
+	// 取消订阅后通道关闭，发布不会阻塞
+	cancel()
+	_, ok := <-events
+	require.False(t, ok)
+	require.True(t, bus.Publish("WxAutoRunner", StateReady, nil))
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(1)
+	defer cancel()
+
+	// 缓冲已满时丢弃事件，当前状态仍可通过 State 获取
+	bus.Publish("WxAutoRunner", StateStarting, nil)
+	bus.Publish("WxAutoRunner", StateReady, nil)
+	require.Equal(t, StateStarting, nextEvent(t, events).State)
+	e, ok := bus.State("WxAutoRunner")
+	require.True(t, ok)
+	require.Equal(t, StateReady, e.State)
+}
+
+func TestWaitReady(t *testing.T) {
+	bus := NewEventBus()
+	bus.Publish("NatsServerRunner", StateStarting, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, bus.WaitReady(ctx, "NatsServerRunner"), context.DeadlineExceeded)
+
+	go bus.Publish("NatsServerRunner", StateReady, nil)
+	require.NoError(t, bus.WaitReady(context.Background(), "NatsServerRunner"))
+	// 已经就绪时立即返回
+	require.NoError(t, bus.WaitReady(context.Background(), "NatsServerRunner"))
+}
+
+func TestSupervisorPublishesStates(t *testing.T) {
+	r := &checkedRunner{}
+	r.run = func(ctx context.Context, n int64) error {
+		<-ctx.Done()
+		return nil
+	}
+	cfg := testSupervisorConfig(RestartNever)
+	cfg.HealthInterval = time.Millisecond
+
+	bus := NewEventBus()
+	events, cancelSub := bus.Subscribe(16)
+	defer cancelSub()
+	ctx, cancel := context.WithCancel(WithEventBus(context.Background(), bus))
+	done := make(chan error, 1)
+	go func() { done <- Supervise(r, cfg).Run(ctx) }()
+
+	require.Equal(t, StateStarting, nextEvent(t, events).State)
+	r.ready.Store(true)
+	require.Equal(t, StateReady, nextEvent(t, events).State)
+
+	r.setHealth(errors.New("mcp server disconnected"))
+	e := nextEvent(t, events)
+	require.Equal(t, StateDegraded, e.State)
+	require.Equal(t, "mcp server disconnected", e.Error)
+
+	// 曾经就绪后不再就绪视为降级
+	r.setHealth(nil)
+	require.Equal(t, StateReady, nextEvent(t, events).State)
+	r.ready.Store(false)
+	e = nextEvent(t, events)
+	require.Equal(t, StateDegraded, e.State)
+	require.Equal(t, errNotReady.Error(), e.Error)
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Equal(t, StateStopped, nextEvent(t, events).State)
+}
+
+func TestSupervisorPublishesRestart(t *testing.T) {
+	// 未实现 HealthChecker 的 runner 启动后即视为就绪，出错退出时记录原因，重启后重新发布
+	r := &funcRunner{run: func(ctx context.Context, n int64) error {
+		if n == 1 {
+			return errors.New("nats unreachable")
+		}
+		return nil
+	}}
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(16)
+	defer cancel()
+	require.NoError(t, Supervise(r, testSupervisorConfig(RestartOnFailure)).Run(WithEventBus(context.Background(), bus)))
+
+	var states []State
+	for range 6 {
+		e := nextEvent(t, events)
+		states = append(states, e.State)
+		if e.State == StateStopped && e.Error != "" {
+			require.Equal(t, "nats unreachable", e.Error)
+		}
+	}
+	require.Equal(t, []State{StateStarting, StateReady, StateStopped, StateStarting, StateReady, StateStopped}, states)
+}
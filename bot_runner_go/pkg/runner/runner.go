@@ -2,10 +2,12 @@ package runner
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -15,41 +17,182 @@ type Runner interface {
 	Run(ctx context.Context) error
 }
 
-func Run(logger zerolog.Logger, svrs ...Runner) {
+type Config struct {
+	Supervisor      SupervisorConfig            `yaml:"supervisor"`       // 默认的监督配置
+	Supervisors     map[string]SupervisorConfig `yaml:"supervisors"`      // 按 runner 名称覆盖的监督配置
+	GracePeriod     time.Duration               `yaml:"grace_period"`     // 每个 runner 停止的宽限时间，超时后不再等待并继续停止下一个，默认 30s
+	ShutdownTimeout time.Duration               `yaml:"shutdown_timeout"` // 停止所有 runner 的最长时间，超时后强制退出，默认 60s
+}
+
+func (c *Config) Validate() error {
+	if c.GracePeriod <= 0 {
+		c.GracePeriod = 30 * time.Second
+	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = 60 * time.Second
+	}
+
+	if c.ShutdownTimeout < c.GracePeriod {
+		return errors.New("shutdown_timeout must not be less than grace_period")
+	}
+	return nil
+}
+
+// supervisorConfig 返回 runner 的监督配置
+func (c *Config) supervisorConfig(name string) *SupervisorConfig {
+	if cfg, ok := c.Supervisors[name]; ok {
+		return &cfg
+	}
+	cfg := c.Supervisor
+	return &cfg
+}
+
+// ErrShutdownTimeout 未能在停止期限内停止所有 runner
+var ErrShutdownTimeout = errors.New("shutdown timed out")
+
+// ErrAllStopped 所有 runner 都已退出且不再重启，进程继续运行也不会处理任何工作
+var ErrAllStopped = errors.New("all runners have stopped")
+
+type ctxKeyGracePeriod struct{}
+
+// ShutdownContext 返回 runner 停止时用于收尾的上下文，不随 ctx 取消，在 runner 的宽限时间后超时
+func ShutdownContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	grace, ok := ctx.Value(ctxKeyGracePeriod{}).(time.Duration)
+	if !ok {
+		grace = 30 * time.Second
+	}
+	return context.WithTimeout(context.WithoutCancel(ctx), grace)
+}
+
+// running 一个正在运行的 runner
+type running struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Run 按监督配置运行所有 runner，直到收到退出信号、某个 runner 出错且要求停止整个进程，或所有 runner 都已退出且不再重启。
+// svrs 按依赖顺序排列，被依赖的 runner 在前，停止时按相反顺序逐个停止。
+// 所有 runner 共享同一个事件总线，可通过 EventBusFromContext 获取并观察其他 runner 的状态变化。
+// 某个 runner 要求停止整个进程时返回对应的 *FatalError，所有 runner 都已退出时返回 ErrAllStopped，停止超时或再次收到退出信号时返回 ErrShutdownTimeout，
+// 调用方应以非零退出码退出
+func Run(logger zerolog.Logger, cfg *Config, svrs ...Runner) error {
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
 	for _, svr := range svrs {
 		if svr == nil {
 			panic("runner cannot be nil")
 		}
 	}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
+	baseCtx := context.WithValue(context.Background(), ctxKeyGracePeriod{}, cfg.GracePeriod)
+	baseCtx = WithEventBus(baseCtx, NewEventBus())
+	fatalCh := make(chan *FatalError, len(svrs))
+	runners := make([]*running, 0, len(svrs))
 	for _, svr := range svrs {
-		wg.Add(1)
-		go func(s Runner, logger zerolog.Logger) {
-			defer wg.Done()
-			logger = logger.With().Str("service", s.Name()).Logger()
-			ctx := logger.WithContext(ctx)
+		s := Supervise(svr, cfg.supervisorConfig(svr.Name()))
+		logger := logger.With().Str("service", s.Name()).Logger()
+		ctx, cancel := context.WithCancel(logger.WithContext(baseCtx))
+		r := &running{name: s.Name(), cancel: cancel, done: make(chan struct{})}
+		runners = append(runners, r)
 
-			if err := s.Run(ctx); err != nil {
+		go func() {
+			defer close(r.done)
+			err := s.Run(ctx)
+			var fatalErr *FatalError
+			switch {
+			case errors.As(err, &fatalErr):
+				logger.Error().Err(err).Msg("stopped due to fatal error")
+				fatalCh <- fatalErr
+			case err != nil:
 				logger.Error().Err(err).Msg("stopped due to error")
-			} else {
+			default:
 				logger.Info().Msg("stopped successfully")
 			}
-		}(svr, logger)
+		}()
 	}
 
-	// 等待所有服务运行完毕
-	signalCh := make(chan os.Signal, 1)
+	allStopped := make(chan struct{})
+	go func() {
+		defer close(allStopped)
+		for _, r := range runners {
+			<-r.done
+		}
+	}()
+
+	// 等待退出信号、致命错误或所有 runner 退出
+	signalCh := make(chan os.Signal, 2)
 	signal.Notify(signalCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGABRT, syscall.SIGSEGV)
 	signal.Ignore(syscall.SIGPIPE, syscall.SIGHUP)
-	logger.Info().Str("signal", (<-signalCh).String()).Msg("received signal, stopping")
+	defer signal.Stop(signalCh)
+	var err error
+	select {
+	case sig := <-signalCh:
+		logger.Info().Str("signal", sig.String()).Msg("received signal, stopping")
+	case fatalErr := <-fatalCh:
+		logger.Error().Err(fatalErr).Msg("runner failed, stopping process")
+		err = fatalErr
+	case <-allStopped:
+		// 致命错误与所有 runner 退出可能同时发生，优先返回致命错误
+		select {
+		case err = <-fatalCh:
+		default:
+			err = ErrAllStopped
+		}
+		logger.Error().Err(err).Msg("all runners have stopped, exiting process")
+	}
 
-	// 发送停止信号给所有服务
-	cancelFunc()
+	if shutdownErr := shutdown(logger, cfg, runners, signalCh); shutdownErr != nil {
+		return shutdownErr
+	}
+	logger.Info().Msg("all servers have stopped")
+	return err
+}
 
-	// 等待所有服务停止
-	wg.Wait()
+// shutdown 按启动的相反顺序逐个停止 runner，超过宽限时间的 runner 不再等待，
+// 超过停止期限或再次收到退出信号时放弃等待
+func shutdown(logger zerolog.Logger, cfg *Config, runners []*running, signalCh <-chan os.Signal) error {
+	deadline := time.NewTimer(cfg.ShutdownTimeout)
+	defer deadline.Stop()
 
-	logger.Info().Msg("all servers have stopped")
+	wait := func(r *running, timeout <-chan time.Time) error {
+		select {
+		case <-r.done:
+			return nil
+		case <-timeout:
+			return nil
+		case <-deadline.C:
+			return fmt.Errorf("%w: %s did not stop within %s", ErrShutdownTimeout, r.name, cfg.ShutdownTimeout)
+		case sig := <-signalCh:
+			return fmt.Errorf("%w: received %s while stopping %s", ErrShutdownTimeout, sig, r.name)
+		}
+	}
+
+	for i := len(runners) - 1; i >= 0; i-- {
+		r := runners[i]
+		logger.Info().Str("service", r.name).Msg("stopping")
+		r.cancel()
+		grace := time.NewTimer(cfg.GracePeriod)
+		err := wait(r, grace.C)
+		grace.Stop()
+		if err != nil {
+			logger.Error().Err(err).Msg("forcing exit")
+			return err
+		}
+		select {
+		case <-r.done:
+		default:
+			logger.Warn().Str("service", r.name).Dur("grace_period", cfg.GracePeriod).Msg("did not stop within grace period")
+		}
+	}
+
+	// 等待超过宽限时间的 runner 在停止期限内停止
+	for _, r := range runners {
+		if err := wait(r, nil); err != nil {
+			logger.Error().Err(err).Msg("forcing exit")
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// startRunning 启动一个停止需要 stopDelay 的 runner，停止时记录名称
+func startRunning(name string, stopDelay time.Duration, stopped *[]string, mu *sync.Mutex) *running {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &running{name: name, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		<-ctx.Done()
+		time.Sleep(stopDelay)
+		mu.Lock()
+		*stopped = append(*stopped, name)
+		mu.Unlock()
+	}()
+	return r
+}
+
+func TestShutdownReverseOrder(t *testing.T) {
+	var mu sync.Mutex
+	var stopped []string
+	runners := []*running{
+		startRunning("NatsServerRunner", 0, &stopped, &mu),
+		startRunning("AdminRunner", 10*time.Millisecond, &stopped, &mu),
+		startRunning("WxAutoRunner", 20*time.Millisecond, &stopped, &mu),
+	}
+	cfg := &Config{GracePeriod: time.Second, ShutdownTimeout: 5 * time.Second}
+
+	// 依赖其他 runner 的 runner 先停止，被依赖的 NATS 服务器最后停止
+	require.NoError(t, shutdown(zerolog.Nop(), cfg, runners, nil))
+	require.Equal(t, []string{"WxAutoRunner", "AdminRunner", "NatsServerRunner"}, stopped)
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	var mu sync.Mutex
+	var stopped []string
+	runners := []*running{
+		startRunning("NatsServerRunner", 0, &stopped, &mu),
+		startRunning("WxAutoRunner", 200*time.Millisecond, &stopped, &mu),
+	}
+	cfg := &Config{GracePeriod: 50 * time.Millisecond, ShutdownTimeout: 5 * time.Second}
+
+	// 超过宽限时间的 runner 不再等待，继续停止下一个，但仍在停止期限内等待其停止
+	require.NoError(t, shutdown(zerolog.Nop(), cfg, runners, nil))
+	require.Equal(t, []string{"NatsServerRunner", "WxAutoRunner"}, stopped)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	var mu sync.Mutex
+	var stopped []string
+	runners := []*running{startRunning("WxAutoRunner", time.Hour, &stopped, &mu)}
+	cfg := &Config{GracePeriod: 10 * time.Millisecond, ShutdownTimeout: 50 * time.Millisecond}
+
+	require.ErrorIs(t, shutdown(zerolog.Nop(), cfg, runners, nil), ErrShutdownTimeout)
+
+	// 停止期间再次收到退出信号时立即放弃等待
+	signalCh := make(chan os.Signal, 1)
+	signalCh <- os.Interrupt
+	cfg.ShutdownTimeout = time.Hour
+	require.ErrorIs(t, shutdown(zerolog.Nop(), cfg, runners, signalCh), ErrShutdownTimeout)
+}
+
+func TestRunExitsWhenAllRunnersStopped(t *testing.T) {
+	// 正常退出且不再重启的 runner 不会要求停止进程，但所有 runner 都退出后进程也应退出
+	r := &funcRunner{run: func(ctx context.Context, n int64) error { return nil }}
+	cfg := &Config{Supervisor: *testSupervisorConfig(RestartNever)}
+	require.ErrorIs(t, Run(zerolog.Nop(), cfg, r), ErrAllStopped)
+
+	// 出错且要求停止进程时返回 *FatalError
+	r = &funcRunner{run: func(ctx context.Context, n int64) error { return errors.New("failed") }}
+	var fatalErr *FatalError
+	require.ErrorAs(t, Run(zerolog.Nop(), cfg, r), &fatalErr)
+}
+
+func TestShutdownContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKeyGracePeriod{}, 50*time.Millisecond))
+	cancel()
+
+	// 收尾上下文不随 runner 的上下文取消，在宽限时间后超时
+	shutdownCtx, cancelShutdown := ShutdownContext(ctx)
+	defer cancelShutdown()
+	require.NoError(t, shutdownCtx.Err())
+	<-shutdownCtx.Done()
+	require.ErrorIs(t, shutdownCtx.Err(), context.DeadlineExceeded)
+}
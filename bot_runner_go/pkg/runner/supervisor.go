@@ -0,0 +1,224 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"      // 退出后不再重启
+	RestartOnFailure RestartPolicy = "on-failure" // 返回错误或 panic 时重启
+	RestartAlways    RestartPolicy = "always"     // 无论是否出错，退出后都重启
+)
+
+type SupervisorConfig struct {
+	Restart        RestartPolicy `yaml:"restart"`         // 重启策略：never/on-failure/always，默认 on-failure
+	InitialBackoff time.Duration `yaml:"initial_backoff"` // 首次重启前的等待时间，默认 1s
+	MaxBackoff     time.Duration `yaml:"max_backoff"`     // 最大等待时间，每次重启等待时间翻倍，默认 1m
+	MaxRestarts    int           `yaml:"max_restarts"`    // 连续重启次数上限，超过后不再重启，0 表示不限制
+	ResetAfter     time.Duration `yaml:"reset_after"`     // 运行超过该时间后视为恢复正常，重置重启次数与等待时间，默认 1m
+	ExitOnFailure  *bool         `yaml:"exit_on_failure"` // 出错且不再重启时停止整个进程并以非零退出码退出，交由 systemd 或 Docker 重启，默认 true
+	HealthInterval time.Duration `yaml:"health_interval"` // 检查 runner 就绪与健康状况的间隔，默认 1s
+}
+
+func (c *SupervisorConfig) Validate() error {
+	if c.Restart == "" {
+		c.Restart = RestartOnFailure
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = 1 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 1 * time.Minute
+	}
+	if c.ResetAfter <= 0 {
+		c.ResetAfter = 1 * time.Minute
+	}
+	if c.HealthInterval <= 0 {
+		c.HealthInterval = 1 * time.Second
+	}
+	if c.ExitOnFailure == nil {
+		exitOnFailure := true
+		c.ExitOnFailure = &exitOnFailure
+	}
+
+	switch c.Restart {
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("unknown restart policy: %s", c.Restart)
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		return errors.New("max_backoff must not be less than initial_backoff")
+	}
+	if c.MaxRestarts < 0 {
+		return errors.New("max_restarts must not be negative")
+	}
+	return nil
+}
+
+// FatalError runner 出错且不再重启，需要停止整个进程
+type FatalError struct {
+	Runner string
+	Err    error
+}
+
+func (e *FatalError) Error() string {
+	return fmt.Sprintf("runner %s failed: %v", e.Runner, e.Err)
+}
+
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+var _ Runner = (*Supervisor)(nil)
+
+// Supervisor 按重启策略运行 runner，退出后按指数退避重启
+type Supervisor struct {
+	runner Runner
+	cfg    *SupervisorConfig
+}
+
+// Supervise 使用监督配置包装 runner，配置无效时 panic
+func Supervise(r Runner, cfg *SupervisorConfig) *Supervisor {
+	if err := cfg.Validate(); err != nil {
+		panic(fmt.Errorf("supervisor of %s: %w", r.Name(), err))
+	}
+	return &Supervisor{
+		runner: r,
+		cfg:    cfg,
+	}
+}
+
+func (s *Supervisor) Name() string {
+	return s.runner.Name()
+}
+
+// Run 运行 runner 直到 ctx 取消或不再重启，出错且开启了 exit_on_failure 时返回 *FatalError
+func (s *Supervisor) Run(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	backoff := s.cfg.InitialBackoff
+	restarts := 0
+	for {
+		started := time.Now()
+		err := s.runOnce(ctx)
+		if ctx.Err() != nil {
+			return err
+		}
+		if time.Since(started) >= s.cfg.ResetAfter {
+			restarts = 0
+			backoff = s.cfg.InitialBackoff
+		}
+
+		if !s.shouldRestart(err) {
+			return s.giveUp(err)
+		}
+		if s.cfg.MaxRestarts > 0 && restarts >= s.cfg.MaxRestarts {
+			logger.Error().Err(err).Int("restarts", restarts).Msg("Runner exceeded max restarts, giving up")
+			if err == nil {
+				err = errors.New("exceeded max restarts")
+			}
+			return s.giveUp(err)
+		}
+
+		restarts++
+		logger.Warn().Err(err).Int("restart", restarts).Dur("backoff", backoff).Msg("Runner exited, restarting")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, s.cfg.MaxBackoff)
+	}
+}
+
+func (s *Supervisor) shouldRestart(err error) bool {
+	switch s.cfg.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	default:
+		return false
+	}
+}
+
+func (s *Supervisor) giveUp(err error) error {
+	if err != nil && *s.cfg.ExitOnFailure {
+		return &FatalError{Runner: s.runner.Name(), Err: err}
+	}
+	return err
+}
+
+// runOnce 运行一次 runner，panic 视为出错，ctx 中有事件总线时发布本次运行的状态变化
+func (s *Supervisor) runOnce(ctx context.Context) (err error) {
+	if bus := EventBusFromContext(ctx); bus != nil {
+		s.publish(ctx, bus, StateStarting, nil)
+		stop := s.watch(ctx, bus)
+		defer func() {
+			stop()
+			s.publish(ctx, bus, StateStopped, err)
+		}()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			zerolog.Ctx(ctx).Error().Str("stack", string(debug.Stack())).Msgf("Runner panicked: %v", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.runner.Run(ctx)
+}
+
+// watch 定期检查 runner 的就绪与健康状况并发布状态变化，直到 ctx 取消或调用返回的 stop，
+// 未实现 HealthChecker 的 runner 立即视为就绪
+func (s *Supervisor) watch(ctx context.Context, bus *EventBus) (stop func()) {
+	checker, ok := s.runner.(HealthChecker)
+	if !ok {
+		s.publish(ctx, bus, StateReady, nil)
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.cfg.HealthInterval)
+		defer ticker.Stop()
+		wasReady := false
+		for {
+			state, err := checkHealth(checker, wasReady)
+			if state != StateStarting {
+				wasReady = true
+			}
+			s.publish(ctx, bus, state, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (s *Supervisor) publish(ctx context.Context, bus *EventBus, state State, err error) {
+	if !bus.Publish(s.Name(), state, err) {
+		return
+	}
+	logger := zerolog.Ctx(ctx)
+	switch state {
+	case StateDegraded:
+		logger.Warn().Err(err).Str("state", string(state)).Msg("Runner state changed")
+	default:
+		logger.Info().Err(err).Str("state", string(state)).Msg("Runner state changed")
+	}
+}
@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// funcRunner 每次运行调用 run，记录运行次数
+type funcRunner struct {
+	runs atomic.Int64
+	run  func(ctx context.Context, n int64) error
+}
+
+func (r *funcRunner) Name() string {
+	return "FuncRunner"
+}
+
+func (r *funcRunner) Run(ctx context.Context) error {
+	return r.run(ctx, r.runs.Add(1))
+}
+
+func testSupervisorConfig(policy RestartPolicy) *SupervisorConfig {
+	return &SupervisorConfig{Restart: policy, InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+}
+
+func TestSupervisorRestartOnFailure(t *testing.T) {
+	// 前两次出错后重启，第三次正常退出后不再重启
+	r := &funcRunner{run: func(ctx context.Context, n int64) error {
+		if n < 3 {
+			return errors.New("nats unreachable")
+		}
+		return nil
+	}}
+	require.NoError(t, Supervise(r, testSupervisorConfig(RestartOnFailure)).Run(context.Background()))
+	require.Equal(t, int64(3), r.runs.Load())
+}
+
+func TestSupervisorRecoversPanic(t *testing.T) {
+	r := &funcRunner{run: func(ctx context.Context, n int64) error {
+		if n == 1 {
+			panic("boom")
+		}
+		return nil
+	}}
+	require.NoError(t, Supervise(r, testSupervisorConfig(RestartOnFailure)).Run(context.Background()))
+	require.Equal(t, int64(2), r.runs.Load())
+}
+
+func TestSupervisorNever(t *testing.T) {
+	errFailed := errors.New("failed")
+	r := &funcRunner{run: func(ctx context.Context, n int64) error { return errFailed }}
+	require.ErrorIs(t, Supervise(r, testSupervisorConfig(RestartNever)).Run(context.Background()), errFailed)
+	require.Equal(t, int64(1), r.runs.Load())
+
+	// 关闭 exit_on_failure 后只返回错误，不要求停止整个进程
+	cfg := testSupervisorConfig(RestartNever)
+	exitOnFailure := false
+	cfg.ExitOnFailure = &exitOnFailure
+	err := Supervise(r, cfg).Run(context.Background())
+	var fatalErr *FatalError
+	require.False(t, errors.As(err, &fatalErr))
+	require.ErrorIs(t, err, errFailed)
+}
+
+func TestSupervisorMaxRestarts(t *testing.T) {
+	r := &funcRunner{run: func(ctx context.Context, n int64) error { return errors.New("failed") }}
+	cfg := testSupervisorConfig(RestartOnFailure)
+	cfg.MaxRestarts = 2
+
+	// 超过重启次数上限后放弃，要求停止整个进程
+	err := Supervise(r, cfg).Run(context.Background())
+	var fatalErr *FatalError
+	require.ErrorAs(t, err, &fatalErr)
+	require.Equal(t, "FuncRunner", fatalErr.Runner)
+	require.Equal(t, int64(3), r.runs.Load())
+}
+
+func TestSupervisorAlwaysStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &funcRunner{run: func(ctx context.Context, n int64) error {
+		if n == 5 {
+			cancel()
+			<-ctx.Done()
+		}
+		return nil
+	}}
+	require.NoError(t, Supervise(r, testSupervisorConfig(RestartAlways)).Run(ctx))
+	require.Equal(t, int64(5), r.runs.Load())
+}
+
+func TestSupervisorConfigOverride(t *testing.T) {
+	cfg := &Config{
+		Supervisor:  SupervisorConfig{Restart: RestartAlways},
+		Supervisors: map[string]SupervisorConfig{"AdminRunner": {Restart: RestartNever}},
+	}
+	require.Equal(t, RestartNever, cfg.supervisorConfig("AdminRunner").Restart)
+	require.Equal(t, RestartAlways, cfg.supervisorConfig("WxAutoRunner").Restart)
+
+	require.Error(t, (&SupervisorConfig{Restart: "sometimes"}).Validate())
+}
@@ -0,0 +1,156 @@
+package testharness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/meguminnnnnnnnn/go-openai"
+)
+
+// ReplyFunc 根据请求中的消息返回模型的回答
+type ReplyFunc func(messages []openai.ChatCompletionMessage) string
+
+// Reply 模型的一次输出，ToolCalls 非空时表示模型请求调用工具，Content 为调用工具前输出的文本
+type Reply struct {
+	Content   string
+	ToolCalls []openai.ToolCall
+	Hold      <-chan struct{} // 流式响应发送完文本后等待 Hold 关闭再结束，用于测试模型输出尚未结束时的行为
+}
+
+// ToolReplyFunc 根据请求中的消息返回模型的输出，可以请求调用工具
+type ToolReplyFunc func(messages []openai.ChatCompletionMessage) Reply
+
+// FakeChatModel 兼容 OpenAI Chat Completions 接口的模型服务，代替真实的模型端点，支持流式与非流式请求
+type FakeChatModel struct {
+	server *httptest.Server
+	reply  ToolReplyFunc
+
+	mu       sync.Mutex
+	requests []openai.ChatCompletionRequest // 收到的请求
+}
+
+// StartFakeChatModel 启动模型服务，测试结束时自动关闭
+func StartFakeChatModel(t testing.TB, reply ReplyFunc) *FakeChatModel {
+	t.Helper()
+	return StartFakeToolCallingModel(t, func(messages []openai.ChatCompletionMessage) Reply {
+		return Reply{Content: reply(messages)}
+	})
+}
+
+// StartFakeToolCallingModel 启动可以请求调用工具的模型服务，测试结束时自动关闭。
+// 流式响应先发送文本再发送工具调用，与部分 OpenAI 兼容服务的行为一致
+func StartFakeToolCallingModel(t testing.TB, reply ToolReplyFunc) *FakeChatModel {
+	t.Helper()
+	m := &FakeChatModel{reply: reply}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// BaseURL 返回模型服务地址，用作模型配置的 base_url
+func (m *FakeChatModel) BaseURL() string {
+	return m.server.URL
+}
+
+// Requests 返回已收到的请求
+func (m *FakeChatModel) Requests() []openai.ChatCompletionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]openai.ChatCompletionRequest(nil), m.requests...)
+}
+
+func (m *FakeChatModel) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/chat/completions" {
+		http.NotFound(w, r)
+		return
+	}
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	m.requests = append(m.requests, req)
+	id := fmt.Sprintf("chatcmpl-%d", len(m.requests))
+	m.mu.Unlock()
+
+	reply := m.reply(req.Messages)
+	content := reply.Content
+	usage := openai.Usage{PromptTokens: 10, CompletionTokens: len([]rune(content)), TotalTokens: 10 + len([]rune(content))}
+	finishReason := openai.FinishReasonStop
+	if len(reply.ToolCalls) > 0 {
+		finishReason = openai.FinishReasonToolCalls
+	}
+	if !req.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			ID:     id,
+			Object: "chat.completion",
+			Model:  req.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content, ToolCalls: reply.ToolCalls},
+				FinishReason: finishReason,
+			}},
+			Usage: usage,
+		})
+		return
+	}
+
+	// 流式响应逐字发送，便于测试按句切分
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	send := func(chunk openai.ChatCompletionStreamResponse) {
+		data, _ := json.Marshal(chunk)
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	for i, r := range []rune(content) {
+		delta := openai.ChatCompletionStreamChoiceDelta{Content: string(r)}
+		if i == 0 {
+			delta.Role = openai.ChatMessageRoleAssistant
+		}
+		send(openai.ChatCompletionStreamResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: delta}},
+		})
+	}
+	if reply.Hold != nil {
+		select {
+		case <-reply.Hold:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	// 工具调用分为两段发送：先发送 ID 与名称，再发送参数
+	for i, call := range reply.ToolCalls {
+		index := i
+		for _, fn := range []openai.FunctionCall{{Name: call.Function.Name}, {Arguments: call.Function.Arguments}} {
+			delta := openai.ToolCall{Index: &index, Function: fn}
+			if fn.Name != "" {
+				delta.ID, delta.Type = call.ID, openai.ToolTypeFunction
+			}
+			send(openai.ChatCompletionStreamResponse{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Model:   req.Model,
+				Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{delta}}}},
+			})
+		}
+	}
+	send(openai.ChatCompletionStreamResponse{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Model:   req.Model,
+		Choices: []openai.ChatCompletionStreamChoice{{FinishReason: finishReason}},
+		Usage:   &usage,
+	})
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+}
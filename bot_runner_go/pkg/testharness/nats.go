@@ -0,0 +1,95 @@
+package testharness
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/require"
+)
+
+// NatsServer 每个测试独立的进程内 NATS 服务器，开启 JetStream，数据存储在测试的临时目录中，测试结束时自动关闭
+type NatsServer struct {
+	t      testing.TB
+	opts   *server.Options
+	server *server.Server
+}
+
+// StartNatsServer 启动 NATS 服务器，监听随机端口
+func StartNatsServer(t testing.TB) *NatsServer {
+	t.Helper()
+	s := &NatsServer{
+		t: t,
+		opts: &server.Options{
+			Host:      "127.0.0.1",
+			Port:      server.RANDOM_PORT,
+			JetStream: true,
+			StoreDir:  t.TempDir(),
+			NoLog:     true,
+			NoSigs:    true,
+		},
+	}
+	s.start()
+	// 重启后沿用同一端口，客户端可以自动重连
+	s.opts.Port = s.server.Addr().(*net.TCPAddr).Port
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func (s *NatsServer) start() {
+	s.t.Helper()
+	srv, err := server.NewServer(s.opts)
+	require.NoError(s.t, err)
+	srv.Start()
+	require.True(s.t, srv.ReadyForConnections(10*time.Second), "nats server is not ready")
+	s.server = srv
+}
+
+// URL 返回客户端连接地址
+func (s *NatsServer) URL() string {
+	return s.server.ClientURL()
+}
+
+// Shutdown 关闭服务器
+func (s *NatsServer) Shutdown() {
+	s.server.Shutdown()
+	s.server.WaitForShutdown()
+}
+
+// Restart 关闭后在同一端口以同一存储目录重新启动，用于测试客户端重连
+func (s *NatsServer) Restart() {
+	s.t.Helper()
+	s.Shutdown()
+	s.start()
+}
+
+// JetStream 返回连接到服务器的 JetStream 上下文，用于准备测试数据与检查结果，连接在测试结束时关闭
+func (s *NatsServer) JetStream() jetstream.JetStream {
+	s.t.Helper()
+	nc, err := nats.Connect(s.URL())
+	require.NoError(s.t, err)
+	s.t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	require.NoError(s.t, err)
+	return js
+}
+
+// CreateStream 创建流
+func (s *NatsServer) CreateStream(cfg jetstream.StreamConfig) jetstream.Stream {
+	s.t.Helper()
+	stream, err := s.JetStream().CreateStream(context.Background(), cfg)
+	require.NoError(s.t, err)
+	return stream
+}
+
+// CreateConsumer 在流上创建持久化消费者
+func (s *NatsServer) CreateConsumer(stream string, cfg jetstream.ConsumerConfig) jetstream.Consumer {
+	s.t.Helper()
+	consumer, err := s.JetStream().CreateConsumer(context.Background(), stream, cfg)
+	require.NoError(s.t, err)
+	return consumer
+}
@@ -0,0 +1,215 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/buildinfo"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/runner"
+)
+
+type Config struct {
+	ListenAddr    string        `yaml:"listen_addr"`    // HTTP 服务器地址
+	StatusTimeout time.Duration `yaml:"status_timeout"` // 获取每个 runner 详细状态的超时时间，默认 5s
+}
+
+func (c *Config) Validate() error {
+	if c.StatusTimeout <= 0 {
+		c.StatusTimeout = 5 * time.Second
+	}
+
+	if c.ListenAddr == "" {
+		return errors.New("listen_addr is required")
+	}
+	return nil
+}
+
+var (
+	_ runner.Runner        = (*AdminRunner)(nil)
+	_ runner.HealthChecker = (*AdminRunner)(nil)
+)
+
+// AdminRunner 管理服务，提供供容器编排使用的 /healthz、/readyz 探针，供看板使用的 /status 状态页，
+// 以及 Prometheus 格式的 /metrics 指标
+type AdminRunner struct {
+	cfg       *Config
+	runners   []runner.Runner // 被观察的 runner，状态页按此顺序展示
+	startedAt time.Time
+	listening atomic.Bool // 是否已开始监听
+}
+
+// MustNew 创建管理服务，runners 为需要观察的其他 runner，配置无效时 panic
+func MustNew(cfg *Config, runners ...runner.Runner) *AdminRunner {
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	return &AdminRunner{
+		cfg:       cfg,
+		runners:   runners,
+		startedAt: time.Now(),
+	}
+}
+
+func (a *AdminRunner) Name() string {
+	return "AdminRunner"
+}
+
+// Ready HTTP 服务器已开始监听
+func (a *AdminRunner) Ready() bool {
+	return a.listening.Load()
+}
+
+func (a *AdminRunner) Health() error {
+	return nil
+}
+
+func (a *AdminRunner) Run(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+
+	bus := runner.EventBusFromContext(ctx)
+	if bus == nil {
+		// 未通过 runner.Run 运行时没有事件总线，所有 runner 均显示为启动中
+		bus = runner.NewEventBus()
+	}
+	server := &http.Server{
+		Handler: a.handler(bus),
+		// 请求沿用 runner 的日志配置，停止时正在处理的请求不随 ctx 取消
+		BaseContext: func(net.Listener) context.Context { return context.WithoutCancel(ctx) },
+	}
+
+	ln, err := net.Listen("tcp", a.cfg.ListenAddr)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to start admin HTTP server")
+		return err
+	}
+	a.listening.Store(true)
+	defer a.listening.Store(false)
+	go func() {
+		logger.Info().Str("addr", ln.Addr().String()).Msg("Admin HTTP server started")
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msg("Admin HTTP server stopped unexpectedly")
+		}
+	}()
+
+	// 等待上下文取消，在宽限时间内等待正在处理的请求完成
+	<-ctx.Done()
+	shutdownCtx, cancel := runner.ShutdownContext(ctx)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error().Err(err).Msg("Failed to shutdown admin HTTP server")
+	} else {
+		logger.Info().Msg("Admin HTTP server stopped successfully")
+	}
+	return nil
+}
+
+func (a *AdminRunner) handler(bus *runner.EventBus) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", a.handleHealthz)
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		a.handleReadyz(w, r, bus)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		a.handleStatus(w, r, bus)
+	})
+	mux.Handle("GET /metrics", promhttp.Handler())
+	return mux
+}
+
+// handleHealthz 存活探针，进程能够响应请求即视为存活，runner 出错由监督者重启，不需要重启整个进程
+func (a *AdminRunner) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// ReadyzResponse /readyz 的响应
+type ReadyzResponse struct {
+	Ready   bool           `json:"ready"`   // 是否所有 runner 均已就绪
+	Runners []runner.Event `json:"runners"` // 各 runner 的当前状态
+}
+
+// handleReadyz 就绪探针，所有 runner 均已就绪或降级时返回 200，否则返回 503
+func (a *AdminRunner) handleReadyz(w http.ResponseWriter, r *http.Request, bus *runner.EventBus) {
+	resp := ReadyzResponse{Runners: a.states(bus)}
+	resp.Ready = allReady(resp.Runners)
+	code := http.StatusOK
+	if !resp.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(r.Context(), w, code, resp)
+}
+
+// RunnerStatus 一个 runner 的当前状态与详细运行状态
+type RunnerStatus struct {
+	runner.Event
+	Details any `json:"details,omitempty"` // 实现 runner.StatusReporter 的 runner 报告的详细状态
+}
+
+// StatusResponse /status 的响应
+type StatusResponse struct {
+	Build     buildinfo.Info `json:"build"`      // 构建信息
+	StartedAt time.Time      `json:"started_at"` // 进程启动时间
+	Uptime    string         `json:"uptime"`     // 已运行时间
+	Ready     bool           `json:"ready"`      // 是否所有 runner 均已就绪
+	Runners   []RunnerStatus `json:"runners"`    // 各 runner 的状态
+}
+
+func (a *AdminRunner) handleStatus(w http.ResponseWriter, r *http.Request, bus *runner.EventBus) {
+	states := a.states(bus)
+	resp := StatusResponse{
+		Build:     buildinfo.Get(),
+		StartedAt: a.startedAt,
+		Uptime:    time.Since(a.startedAt).Round(time.Second).String(),
+		Ready:     allReady(states),
+		Runners:   make([]RunnerStatus, 0, len(states)),
+	}
+	for i, e := range states {
+		st := RunnerStatus{Event: e}
+		if reporter, ok := a.runners[i].(runner.StatusReporter); ok {
+			ctx, cancel := context.WithTimeout(r.Context(), a.cfg.StatusTimeout)
+			st.Details = reporter.Status(ctx)
+			cancel()
+		}
+		resp.Runners = append(resp.Runners, st)
+	}
+	writeJSON(r.Context(), w, http.StatusOK, resp)
+}
+
+// states 按 runners 的顺序返回各 runner 的当前状态，尚未发布过状态的 runner 视为启动中
+func (a *AdminRunner) states(bus *runner.EventBus) []runner.Event {
+	ret := make([]runner.Event, 0, len(a.runners))
+	for _, r := range a.runners {
+		e, ok := bus.State(r.Name())
+		if !ok {
+			e = runner.Event{Runner: r.Name(), State: runner.StateStarting}
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+func allReady(states []runner.Event) bool {
+	for _, e := range states {
+		if e.State != runner.StateReady && e.State != runner.StateDegraded {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to write response")
+	}
+}
@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/buildinfo"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/runner"
+)
+
+type fakeRunner struct {
+	name string
+}
+
+func (r *fakeRunner) Name() string {
+	return r.name
+}
+
+func (r *fakeRunner) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// reportingRunner 报告详细状态的 runner
+type reportingRunner struct {
+	fakeRunner
+}
+
+func (r *reportingRunner) Status(ctx context.Context) any {
+	return map[string]any{"num_pending": 3}
+}
+
+func newTestServer(t *testing.T, bus *runner.EventBus) *httptest.Server {
+	a := MustNew(&Config{ListenAddr: "127.0.0.1:0"},
+		&fakeRunner{name: "NatsServerRunner"},
+		&reportingRunner{fakeRunner{name: "WxAutoRunner"}},
+	)
+	srv := httptest.NewServer(a.handler(bus))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, url string, v any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if v != nil {
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+func TestHealthz(t *testing.T) {
+	srv := newTestServer(t, runner.NewEventBus())
+	require.Equal(t, http.StatusOK, get(t, srv.URL+"/healthz", nil))
+}
+
+func TestReadyz(t *testing.T) {
+	bus := runner.NewEventBus()
+	srv := newTestServer(t, bus)
+
+	// 尚未发布状态的 runner 视为启动中
+	var resp ReadyzResponse
+	require.Equal(t, http.StatusServiceUnavailable, get(t, srv.URL+"/readyz", &resp))
+	require.False(t, resp.Ready)
+	require.Equal(t, runner.StateStarting, resp.Runners[1].State)
+
+	// 降级的 runner 仍在工作，视为就绪
+	bus.Publish("NatsServerRunner", runner.StateReady, nil)
+	bus.Publish("WxAutoRunner", runner.StateDegraded, errors.New("mcp server disconnected"))
+	require.Equal(t, http.StatusOK, get(t, srv.URL+"/readyz", &resp))
+	require.True(t, resp.Ready)
+	require.Equal(t, "mcp server disconnected", resp.Runners[1].Error)
+
+	bus.Publish("WxAutoRunner", runner.StateStopped, errors.New("nats unreachable"))
+	require.Equal(t, http.StatusServiceUnavailable, get(t, srv.URL+"/readyz", &resp))
+}
+
+func TestStatus(t *testing.T) {
+	bus := runner.NewEventBus()
+	bus.Publish("NatsServerRunner", runner.StateReady, nil)
+	bus.Publish("WxAutoRunner", runner.StateReady, nil)
+	srv := newTestServer(t, bus)
+
+	var resp struct {
+		Build   buildinfo.Info `json:"build"`
+		Ready   bool           `json:"ready"`
+		Runners []struct {
+			Runner  string         `json:"runner"`
+			State   runner.State   `json:"state"`
+			Details map[string]any `json:"details"`
+		} `json:"runners"`
+	}
+	require.Equal(t, http.StatusOK, get(t, srv.URL+"/status", &resp))
+	require.Equal(t, buildinfo.Get().Version, resp.Build.Version)
+	require.True(t, resp.Ready)
+	require.Len(t, resp.Runners, 2)
+	require.Equal(t, "NatsServerRunner", resp.Runners[0].Runner)
+	require.Nil(t, resp.Runners[0].Details)
+	require.Equal(t, runner.StateReady, resp.Runners[1].State)
+	require.Equal(t, float64(3), resp.Runners[1].Details["num_pending"])
+}
+
+func TestMetrics(t *testing.T) {
+	srv := newTestServer(t, runner.NewEventBus())
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "go_goroutines")
+}
+
+func TestRun(t *testing.T) {
+	a := MustNew(&Config{ListenAddr: "127.0.0.1:0"})
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	require.Eventually(t, a.Ready, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.False(t, a.Ready())
+}
@@ -0,0 +1,36 @@
+package natsserver
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// serverLogger 把 NATS 服务器的日志转发到 zerolog
+type serverLogger struct {
+	logger *zerolog.Logger
+}
+
+func (l *serverLogger) Noticef(format string, v ...any) {
+	l.logger.Info().Msg(fmt.Sprintf(format, v...))
+}
+
+func (l *serverLogger) Warnf(format string, v ...any) {
+	l.logger.Warn().Msg(fmt.Sprintf(format, v...))
+}
+
+func (l *serverLogger) Fatalf(format string, v ...any) {
+	l.logger.Error().Msg(fmt.Sprintf(format, v...))
+}
+
+func (l *serverLogger) Errorf(format string, v ...any) {
+	l.logger.Error().Msg(fmt.Sprintf(format, v...))
+}
+
+func (l *serverLogger) Debugf(format string, v ...any) {
+	l.logger.Debug().Msg(fmt.Sprintf(format, v...))
+}
+
+func (l *serverLogger) Tracef(format string, v ...any) {
+	l.logger.Trace().Msg(fmt.Sprintf(format, v...))
+}
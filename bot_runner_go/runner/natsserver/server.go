@@ -0,0 +1,244 @@
+package natsserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsconn"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/runner"
+)
+
+type Config struct {
+	Name         string        `yaml:"name"`          // 进程内连接名称，生产者、消费者等通过 inprocess://<name> 连接，默认 embedded
+	StoreDir     string        `yaml:"store_dir"`     // JetStream 文件存储目录
+	ListenAddr   string        `yaml:"listen_addr"`   // 对外监听地址，供 wxauto 等外部进程连接，为空时只接受进程内连接
+	MaxMemory    int64         `yaml:"max_memory"`    // JetStream 内存存储上限（字节），0 表示由服务器决定
+	MaxStore     int64         `yaml:"max_store"`     // JetStream 文件存储上限（字节），0 表示由服务器决定
+	ReadyTimeout time.Duration `yaml:"ready_timeout"` // 等待服务器就绪的最长时间，默认 10s
+}
+
+func (c *Config) Validate() error {
+	if c.Name == "" {
+		c.Name = "embedded"
+	}
+	if c.ReadyTimeout <= 0 {
+		c.ReadyTimeout = 10 * time.Second
+	}
+
+	if c.StoreDir == "" {
+		return errors.New("store_dir is required")
+	}
+	if c.ListenAddr != "" {
+		if _, _, err := splitHostPort(c.ListenAddr); err != nil {
+			return fmt.Errorf("invalid listen_addr: %w", err)
+		}
+	}
+	if c.MaxMemory < 0 || c.MaxStore < 0 {
+		return errors.New("max_memory and max_store must not be negative")
+	}
+	return nil
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, p, nil
+}
+
+// options 转换为服务器配置，不监听端口时只接受进程内连接
+func (c *Config) options() *server.Options {
+	opts := &server.Options{
+		ServerName:         c.Name,
+		JetStream:          true,
+		StoreDir:           c.StoreDir,
+		JetStreamMaxMemory: c.MaxMemory,
+		JetStreamMaxStore:  c.MaxStore,
+		NoSigs:             true,
+		DontListen:         c.ListenAddr == "",
+	}
+	if c.ListenAddr != "" {
+		opts.Host, opts.Port, _ = splitHostPort(c.ListenAddr)
+	}
+	return opts
+}
+
+var (
+	_ runner.Runner         = (*NatsServerRunner)(nil)
+	_ runner.HealthChecker  = (*NatsServerRunner)(nil)
+	_ runner.StatusReporter = (*NatsServerRunner)(nil)
+)
+
+// NatsServerRunner 在进程内运行开启 JetStream 的 NATS 服务器，用于单进程部署与本地测试
+type NatsServerRunner struct {
+	cfg *Config
+
+	mu      sync.Mutex
+	server  *server.Server // 当前的服务器实例
+	started bool           // 当前实例是否已启动过，已关闭的实例不能再次启动
+
+	ready atomic.Bool // 服务器是否已就绪，可以接受连接
+}
+
+// MustNew 创建服务器并以 cfg.Name 注册进程内连接，服务器在 Run 时启动
+func MustNew(cfg *Config) *NatsServerRunner {
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	s, err := server.NewServer(cfg.options())
+	if err != nil {
+		panic(err)
+	}
+	r := &NatsServerRunner{
+		cfg:    cfg,
+		server: s,
+	}
+	natsconn.RegisterInProcess(cfg.Name, r)
+	return r
+}
+
+func (r *NatsServerRunner) Name() string {
+	return "NatsServerRunner"
+}
+
+// InProcessConn 等待服务器就绪后建立进程内连接，实现 nats.InProcessConnProvider
+func (r *NatsServerRunner) InProcessConn() (net.Conn, error) {
+	s := r.current()
+	if !s.ReadyForConnections(r.cfg.ReadyTimeout) {
+		return nil, errors.New("embedded nats server is not ready")
+	}
+	return s.InProcessConn()
+}
+
+func (r *NatsServerRunner) current() *server.Server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.server
+}
+
+// prepare 返回可以启动的服务器实例，当前实例已启动过时创建新的实例
+func (r *NatsServerRunner) prepare() (*server.Server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		if err := r.renewLocked(); err != nil {
+			return nil, err
+		}
+	}
+	r.started = true
+	return r.server, nil
+}
+
+// renew 创建新的服务器实例供下次运行使用
+func (r *NatsServerRunner) renew() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.renewLocked()
+}
+
+func (r *NatsServerRunner) renewLocked() error {
+	s, err := server.NewServer(r.cfg.options())
+	if err != nil {
+		return err
+	}
+	r.server = s
+	r.started = false
+	return nil
+}
+
+// Ready 服务器已启动并可以接受连接
+func (r *NatsServerRunner) Ready() bool {
+	return r.ready.Load()
+}
+
+// Health 检查服务器仍在运行且 JetStream 可用
+func (r *NatsServerRunner) Health() error {
+	s := r.current()
+	if !s.Running() {
+		return errors.New("embedded nats server is not running")
+	}
+	if !s.JetStreamEnabled() {
+		return errors.New("jetstream is not enabled")
+	}
+	return nil
+}
+
+// Status 内嵌服务器的运行状态
+type Status struct {
+	Running     bool   `json:"running"`         // 是否正在运行
+	ClientURL   string `json:"client_url"`      // 客户端连接地址
+	Connections int    `json:"connections"`     // 当前客户端连接数，包含进程内连接
+	Streams     int    `json:"streams"`         // JetStream 流的数量
+	Consumers   int    `json:"consumers"`       // JetStream 消费者的数量
+	Messages    uint64 `json:"messages"`        // 所有流中的消息数
+	Bytes       uint64 `json:"bytes"`           // 所有流中的消息大小（字节）
+	Error       string `json:"error,omitempty"` // 获取 JetStream 状态失败的原因
+}
+
+func (r *NatsServerRunner) Status(ctx context.Context) any {
+	s := r.current()
+	st := Status{Running: s.Running(), ClientURL: r.ClientURL()}
+	if !st.Running {
+		return st
+	}
+	st.Connections = s.NumClients()
+	jsz, err := s.Jsz(nil)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	st.Streams, st.Consumers, st.Messages, st.Bytes = jsz.Streams, jsz.Consumers, jsz.Messages, jsz.Bytes
+	return st
+}
+
+// ClientURL 返回对外监听的地址，只接受进程内连接时返回进程内连接地址
+func (r *NatsServerRunner) ClientURL() string {
+	if r.cfg.ListenAddr == "" {
+		return natsconn.InProcessScheme + "://" + r.cfg.Name
+	}
+	return r.current().ClientURL()
+}
+
+func (r *NatsServerRunner) Run(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+
+	s, err := r.prepare()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create embedded NATS server")
+		return err
+	}
+	s.SetLoggerV2(&serverLogger{logger: logger}, logger.GetLevel() <= zerolog.DebugLevel, logger.GetLevel() <= zerolog.TraceLevel, false)
+	s.Start()
+	if !s.ReadyForConnections(r.cfg.ReadyTimeout) {
+		s.Shutdown()
+		return errors.New("embedded nats server did not become ready in time")
+	}
+	r.ready.Store(true)
+	logger.Info().Str("store_dir", r.cfg.StoreDir).Str("client_url", r.ClientURL()).Msg("Embedded NATS server started")
+
+	// 等待上下文取消
+	<-ctx.Done()
+	r.ready.Store(false)
+	s.Shutdown()
+	s.WaitForShutdown()
+	logger.Info().Msg("Embedded NATS server stopped")
+
+	// 提前创建下次运行的实例，重启期间建立的进程内连接会等待新实例就绪
+	if err := r.renew(); err != nil {
+		logger.Error().Err(err).Msg("Failed to create embedded NATS server for restart")
+	}
+	return nil
+}
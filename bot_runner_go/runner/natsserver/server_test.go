@@ -0,0 +1,75 @@
+package natsserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsconn"
+)
+
+func TestInProcessJetStream(t *testing.T) {
+	r := MustNew(&Config{Name: t.Name(), StoreDir: t.TempDir()})
+	t.Cleanup(func() { natsconn.UnregisterInProcess(t.Name()) })
+	require.Equal(t, "inprocess://"+t.Name(), r.ClientURL())
+
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	// 服务器启动前即可连接，连接会等待服务器就绪
+	nc, err := natsconn.Connect(r.ClientURL())
+	require.NoError(t, err)
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "TEST_STREAM", Subjects: []string{"test.*"}, Storage: nats.FileStorage})
+	require.NoError(t, err)
+	ack, err := js.Publish("test.subject", []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), ack.Sequence)
+	nc.Close()
+	require.Eventually(t, r.Ready, time.Second, 10*time.Millisecond)
+	require.NoError(t, r.Health())
+
+	cancel()
+	require.NoError(t, <-done)
+	require.False(t, r.Ready())
+}
+
+func TestConnectUnregistered(t *testing.T) {
+	_, err := natsconn.Connect("inprocess://missing")
+	require.Error(t, err)
+}
+
+func TestRestart(t *testing.T) {
+	r := MustNew(&Config{Name: t.Name(), StoreDir: t.TempDir()})
+	t.Cleanup(func() { natsconn.UnregisterInProcess(t.Name()) })
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	// 监督者重启 runner 时创建新的服务器实例，沿用同一存储目录
+	for range 2 {
+		ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+		done := make(chan error, 1)
+		go func() { done <- r.Run(ctx) }()
+
+		nc, err := natsconn.Connect(r.ClientURL())
+		require.NoError(t, err)
+		js, err := nc.JetStream()
+		require.NoError(t, err)
+		_, err = js.StreamInfo("TEST_STREAM")
+		if err != nil {
+			_, err = js.AddStream(&nats.StreamConfig{Name: "TEST_STREAM", Subjects: []string{"test.*"}, Storage: nats.FileStorage})
+		}
+		require.NoError(t, err)
+		_, err = js.Publish("test.subject", []byte("hello"))
+		require.NoError(t, err)
+		nc.Close()
+
+		cancel()
+		require.NoError(t, <-done)
+	}
+}
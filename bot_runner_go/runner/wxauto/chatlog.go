@@ -0,0 +1,104 @@
+package wxauto
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/reactagent"
+)
+
+const (
+	chatLogSize  = 50   // 每个会话保留的最近消息条数
+	chatLogChats = 1000 // 最多记录的会话数，超出时淘汰最久没有收到消息的会话
+)
+
+var _ reactagent.ChatLog = (*chatLog)(nil)
+
+type chatLogEntry struct {
+	id  string
+	msg reactagent.ChatMessage
+}
+
+type chatLogChat struct {
+	name    string
+	entries []chatLogEntry
+}
+
+// chatLog 在内存中记录每个会话最近收到的消息，包括未通过过滤规则的消息，供 recent_messages 内置工具使用；
+// 只包含本进程收到的消息，重启后清空；记录的会话数有上限，按 LRU 淘汰
+type chatLog struct {
+	capacity int
+	mu       sync.Mutex
+	ll       *list.List
+	chats    map[string]*list.Element
+	now      func() time.Time
+}
+
+func newChatLog() *chatLog {
+	return &chatLog{
+		capacity: chatLogChats,
+		ll:       list.New(),
+		chats:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Add 记录收到的消息，重新投递的消息只记录一次
+func (l *chatLog) Add(msg *ReceivedMessage) {
+	if msg.Info.ChatName == "" || msg.Content == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.chats[msg.Info.ChatName]
+	if !ok {
+		elem = l.ll.PushFront(&chatLogChat{name: msg.Info.ChatName})
+		l.chats[msg.Info.ChatName] = elem
+		if l.ll.Len() > l.capacity {
+			oldest := l.ll.Back()
+			l.ll.Remove(oldest)
+			delete(l.chats, oldest.Value.(*chatLogChat).name)
+		}
+	}
+	l.ll.MoveToFront(elem)
+	chat := elem.Value.(*chatLogChat)
+
+	entries := chat.entries
+	if msg.ID != "" && slices.ContainsFunc(entries, func(e chatLogEntry) bool { return e.id == msg.ID }) {
+		return
+	}
+	entries = append(entries, chatLogEntry{
+		id: msg.ID,
+		msg: reactagent.ChatMessage{
+			Sender:  msg.Sender,
+			Content: msg.Content,
+			Time:    l.now(),
+		},
+	})
+	if len(entries) > chatLogSize {
+		entries = slices.Delete(entries, 0, len(entries)-chatLogSize)
+	}
+	chat.entries = entries
+}
+
+func (l *chatLog) RecentMessages(ctx context.Context, chatName string, limit int) ([]reactagent.ChatMessage, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []chatLogEntry
+	if elem, ok := l.chats[chatName]; ok {
+		entries = elem.Value.(*chatLogChat).entries
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	ret := make([]reactagent.ChatMessage, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, e.msg)
+	}
+	return ret, nil
+}
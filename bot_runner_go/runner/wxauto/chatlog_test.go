@@ -0,0 +1,44 @@
+package wxauto
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChatLog(t *testing.T) {
+	log := newChatLog()
+	ctx := context.Background()
+	for i := range chatLogSize + 5 {
+		log.Add(&ReceivedMessage{ID: fmt.Sprintf("msg-%d", i), Content: fmt.Sprint(i), Sender: "alice", Info: ChatInfo{ChatName: "测试群"}})
+	}
+	// 重新投递的消息只记录一次
+	log.Add(&ReceivedMessage{ID: fmt.Sprintf("msg-%d", chatLogSize+4), Content: "重复", Info: ChatInfo{ChatName: "测试群"}})
+
+	// 每个会话只保留最近的消息
+	msgs, err := log.RecentMessages(ctx, "测试群", 100)
+	require.NoError(t, err)
+	require.Len(t, msgs, chatLogSize)
+	require.Equal(t, "5", msgs[0].Content)
+
+	msgs, err = log.RecentMessages(ctx, "测试群", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{fmt.Sprint(chatLogSize + 3), fmt.Sprint(chatLogSize + 4)}, []string{msgs[0].Content, msgs[1].Content})
+
+	msgs, err = log.RecentMessages(ctx, "其他群", 10)
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+
+	// 超出会话数上限时淘汰最久没有收到消息的会话
+	log.capacity = 2
+	log.Add(&ReceivedMessage{Content: "你好", Info: ChatInfo{ChatName: "群A"}})
+	log.Add(&ReceivedMessage{Content: "你好", Info: ChatInfo{ChatName: "群B"}})
+	msgs, err = log.RecentMessages(ctx, "测试群", 10)
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+	msgs, err = log.RecentMessages(ctx, "群A", 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+}
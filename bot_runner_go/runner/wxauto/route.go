@@ -0,0 +1,154 @@
+package wxauto
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"text/template"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/chathistory"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/reactagent"
+)
+
+// 默认 Agent 配置的名称，对应顶层的 react_agent 与 user_message_template
+const DefaultAgentProfile = "default"
+
+type AgentProfileConfig struct {
+	ReactAgent          reactagent.Config `yaml:"react_agent"`           // React Agent 配置
+	UserMessageTemplate string            `yaml:"user_message_template"` // 用户消息模板，为空时使用默认模板
+}
+
+type AgentRouteConfig struct {
+	Profile string `yaml:"profile"` // 命中规则后使用的 Agent 配置名称
+	Rule    string `yaml:"rule"`    // 路由规则，使用 expr 语言编写，环境为 ReceivedMessage
+}
+
+// agentProfile 一个命名的 Agent 配置，拥有独立的系统提示词、模型、工具与用户消息模板
+type agentProfile struct {
+	name                string
+	reactAgentCfg       *reactagent.Config
+	userMessageTemplate *template.Template
+}
+
+type agentRoute struct {
+	profile *agentProfile
+	rule    string
+	program *vm.Program
+}
+
+// 编译所有 Agent 配置与路由规则
+func compileAgentProfiles(cfg *Config) (profiles map[string]*agentProfile, routes []*agentRoute, err error) {
+	defaultTpl, err := template.New(DefaultAgentProfile).Parse(cfg.UserMessageTemplate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse user_message_template: %w", err)
+	}
+	profiles = map[string]*agentProfile{
+		DefaultAgentProfile: {
+			name:                DefaultAgentProfile,
+			reactAgentCfg:       &cfg.ReactAgent,
+			userMessageTemplate: defaultTpl,
+		},
+	}
+
+	for name, profileCfg := range cfg.AgentProfiles {
+		if name == DefaultAgentProfile {
+			return nil, nil, fmt.Errorf("agent profile name %q is reserved", DefaultAgentProfile)
+		}
+		if profileCfg == nil {
+			return nil, nil, fmt.Errorf("agent profile %s is empty", name)
+		}
+		tpl := defaultTpl
+		if profileCfg.UserMessageTemplate != "" {
+			tpl, err = template.New(name).Parse(profileCfg.UserMessageTemplate)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse user_message_template of agent profile %s: %w", name, err)
+			}
+		}
+		profiles[name] = &agentProfile{
+			name:                name,
+			reactAgentCfg:       &profileCfg.ReactAgent,
+			userMessageTemplate: tpl,
+		}
+	}
+
+	// NATS KV 历史存储默认复用消费者的 NATS 地址
+	for _, profile := range profiles {
+		if history := profile.reactAgentCfg.History; history != nil &&
+			history.Store.Type == chathistory.StoreTypeNatsKV && history.Store.NatsURL == "" {
+			history.Store.NatsURL = cfg.Consumer.NatsURL
+		}
+	}
+
+	for i, routeCfg := range cfg.AgentRoutes {
+		profile, ok := profiles[routeCfg.Profile]
+		if !ok {
+			return nil, nil, fmt.Errorf("agent_routes[%d]: unknown agent profile %q", i, routeCfg.Profile)
+		}
+		program, err := expr.Compile(
+			routeCfg.Rule,
+			expr.Env(ReceivedMessage{}),
+			expr.AsBool(),
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("agent_routes[%d]: failed to compile rule: %w", i, err)
+		}
+		routes = append(routes, &agentRoute{
+			profile: profile,
+			rule:    routeCfg.Rule,
+			program: program,
+		})
+	}
+	return profiles, routes, nil
+}
+
+// route 按顺序匹配路由规则，返回第一个命中的 Agent 配置，均未命中时使用默认配置
+func (b *WxAutoRunner) route(ctx context.Context, msg *ReceivedMessage) *agentProfile {
+	logger := zerolog.Ctx(ctx)
+	for _, route := range b.routes {
+		ret, err := expr.Run(route.program, *msg)
+		if err != nil {
+			logger.Error().Err(err).Str("rule", route.rule).Msg("Failed to run agent route rule, skipping")
+			continue
+		}
+		if matched, _ := ret.(bool); matched {
+			return route.profile
+		}
+	}
+	return b.profiles[DefaultAgentProfile]
+}
+
+// 创建所有 Agent 配置对应的 ReactAgent，任意一个失败时关闭已创建的实例；
+// 历史存储配置相同的 Agent 配置共享同一个存储，同一会话被路由到不同的 Agent 配置时仍能读到之前的历史
+func (b *WxAutoRunner) newReactAgents(ctx context.Context, opts ...reactagent.Option) (map[string]*reactagent.ReactAgent, error) {
+	opts = append(slices.Clip(opts), reactagent.WithHistoryStores(chathistory.NewStores()))
+	agents := make(map[string]*reactagent.ReactAgent, len(b.profiles))
+	names := make([]string, 0, len(b.profiles))
+	for name := range b.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logger := zerolog.Ctx(ctx).With().Str("agent_profile", name).Logger()
+		agent, err := reactagent.New(logger.WithContext(ctx), b.profiles[name].reactAgentCfg, opts...)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to create ReactAgent")
+			closeReactAgents(ctx, agents)
+			return nil, err
+		}
+		agents[name] = agent
+	}
+	return agents, nil
+}
+
+func closeReactAgents(ctx context.Context, agents map[string]*reactagent.ReactAgent) {
+	logger := zerolog.Ctx(ctx)
+	for name, agent := range agents {
+		if err := agent.Close(); err != nil {
+			logger.Error().Err(err).Str("agent_profile", name).Msg("Failed to close ReactAgent")
+		}
+	}
+}
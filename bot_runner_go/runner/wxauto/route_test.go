@@ -0,0 +1,86 @@
+package wxauto
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/meguminnnnnnnnn/go-openai"
+	"github.com/stretchr/testify/require"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/chathistory"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/reactagent"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/testharness"
+)
+
+func TestAgentRoute(t *testing.T) {
+	cfg := &Config{
+		UserMessageTemplate: "{{.Content}}",
+		AgentProfiles: map[string]*AgentProfileConfig{
+			"coder": {UserMessageTemplate: "code: {{.Content}}"},
+			"admin": {},
+		},
+		AgentRoutes: []AgentRouteConfig{
+			{Profile: "coder", Rule: `Info.ChatName == "技术交流群" || Content startsWith "/code"`},
+			{Profile: "admin", Rule: `Sender == "alice"`},
+		},
+	}
+	profiles, routes, err := compileAgentProfiles(cfg)
+	require.NoError(t, err)
+	b := &WxAutoRunner{cfg: cfg, profiles: profiles, routes: routes}
+	ctx := context.Background()
+
+	require.Equal(t, "coder", b.route(ctx, &ReceivedMessage{Info: ChatInfo{ChatName: "技术交流群"}}).name)
+	require.Equal(t, "coder", b.route(ctx, &ReceivedMessage{Content: "/code hello", Sender: "alice"}).name)
+	require.Equal(t, "admin", b.route(ctx, &ReceivedMessage{Sender: "alice"}).name)
+	require.Equal(t, DefaultAgentProfile, b.route(ctx, &ReceivedMessage{Sender: "bob"}).name)
+
+	// 未配置模板的 Agent 配置沿用默认模板
+	require.Same(t, profiles[DefaultAgentProfile].userMessageTemplate, profiles["admin"].userMessageTemplate)
+}
+
+func TestAgentRouteUnknownProfile(t *testing.T) {
+	_, _, err := compileAgentProfiles(&Config{
+		AgentRoutes: []AgentRouteConfig{{Profile: "missing", Rule: "true"}},
+	})
+	require.Error(t, err)
+}
+
+func TestAgentProfilesShareHistoryStore(t *testing.T) {
+	chat := testharness.StartFakeChatModel(t, echoReply)
+	path := filepath.Join(t.TempDir(), "history.db")
+	newAgentConfig := func() reactagent.Config {
+		return reactagent.Config{
+			Models: []reactagent.ModelConfig{
+				{Name: "fake", BaseURL: chat.BaseURL(), APIKey: "test", Model: "fake-model"},
+			},
+			History: &chathistory.Config{Store: chathistory.StoreConfig{Type: chathistory.StoreTypeFile, Path: path}},
+		}
+	}
+	cfg := &Config{
+		ReactAgent:    newAgentConfig(),
+		AgentProfiles: map[string]*AgentProfileConfig{"coder": {ReactAgent: newAgentConfig()}},
+	}
+	profiles, routes, err := compileAgentProfiles(cfg)
+	require.NoError(t, err)
+	b := &WxAutoRunner{cfg: cfg, profiles: profiles, routes: routes}
+
+	// 两个 Agent 配置使用同一个历史文件，文件只打开一次
+	ctx := testContext(t)
+	agents, err := b.newReactAgents(ctx)
+	require.NoError(t, err)
+	defer closeReactAgents(ctx, agents)
+
+	// 同一会话换用其他 Agent 配置时仍能读到之前的历史
+	_, err = agents[DefaultAgentProfile].Question(ctx, "测试群", "你好")
+	require.NoError(t, err)
+	_, err = agents["coder"].Question(ctx, "测试群", "在吗")
+	require.NoError(t, err)
+	requests := chat.Requests()
+	require.Len(t, requests, 2)
+	var contents []string
+	for _, m := range requests[1].Messages[1:] {
+		contents = append(contents, m.Content)
+	}
+	require.Equal(t, []string{"你好", "收到：你好", "在吗"}, contents)
+	require.Equal(t, openai.ChatMessageRoleUser, requests[1].Messages[1].Role)
+}
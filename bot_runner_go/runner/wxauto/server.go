@@ -4,41 +4,77 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"strings"
-	"text/template"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync/atomic"
 
 	"github.com/expr-lang/expr"
 	"github.com/expr-lang/expr/vm"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/envelope"
 	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsconsumer"
 	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsproducer"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsprovision"
 	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/reactagent"
 	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/runner"
 )
 
 type Config struct {
-	Producer               natsproducer.Config `yaml:"producer"`                  // NATS 生产者配置
-	Consumer               natsconsumer.Config `yaml:"consumer"`                  // NATS 消费者配置
-	ReactAgent             reactagent.Config   `yaml:"react_agent"`               // React Agent 配置
-	UserMessageTemplate    string              `yaml:"user_message_template"`     // 用户消息模板
-	UserMessageReplyFilter string              `yaml:"user_message_reply_filter"` // 用户消息回复过滤器，使用 expr 语言编写的过滤规则
+	Producer               natsproducer.Config   `yaml:"producer"`                  // NATS 生产者配置
+	Consumer               natsconsumer.Config   `yaml:"consumer"`                  // NATS 消费者配置
+	ReactAgent             reactagent.Config     `yaml:"react_agent"`               // React Agent 配置
+	UserMessageTemplate    string                `yaml:"user_message_template"`     // 用户消息模板
+	UserMessageReplyFilter string                `yaml:"user_message_reply_filter"` // 用户消息回复过滤器，使用 expr 语言编写的过滤规则
+	Streaming              StreamingConfig       `yaml:"streaming"`                 // 流式回复配置
+	Provision              *natsprovision.Config `yaml:"provision"`                 // 启动时创建或更新的流与消费者，不配置则需手动创建
+
+	AgentProfiles map[string]*AgentProfileConfig `yaml:"agent_profiles"` // 命名的 Agent 配置，名称 default 保留给顶层配置
+	AgentRoutes   []AgentRouteConfig             `yaml:"agent_routes"`   // Agent 路由表，按顺序匹配，均未命中时使用默认配置
 }
 
-var _ runner.Runner = (*WxAutoRunner)(nil)
+var (
+	_ runner.Runner         = (*WxAutoRunner)(nil)
+	_ runner.HealthChecker  = (*WxAutoRunner)(nil)
+	_ runner.StatusReporter = (*WxAutoRunner)(nil)
+)
 
 type WxAutoRunner struct {
-	cfg                 *Config            // 配置
-	userMessageTemplate *template.Template // 用户消息模板
-	msgFilter           *vm.Program        // 消息过滤器，使用 expr 语言编写的过滤规则
+	cfg       *Config                  // 配置
+	profiles  map[string]*agentProfile // 所有 Agent 配置，包含默认配置
+	routes    []*agentRoute            // Agent 路由表
+	msgFilter *vm.Program              // 消息过滤器，使用 expr 语言编写的过滤规则
+	chatLog   *chatLog                 // 各会话最近收到的消息
+
+	running atomic.Pointer[runState] // 运行期间创建的组件，未运行时为 nil
+}
+
+// runState 一次运行中创建的组件，用于检查就绪与健康状况
+type runState struct {
+	producer    *natsproducer.Producer
+	consumer    *natsconsumer.Consumer
+	reactAgents map[string]*reactagent.ReactAgent
 }
 
 func MustNew(cfg *Config) *WxAutoRunner {
 	if err := cfg.Consumer.Validate(); err != nil {
 		panic(err)
 	}
+	if err := cfg.Streaming.Validate(); err != nil {
+		panic(err)
+	}
+	if cfg.Provision != nil {
+		if cfg.Provision.NatsURL == "" {
+			cfg.Provision.NatsURL = cfg.Consumer.NatsURL
+		}
+		if err := cfg.Provision.Validate(); err != nil {
+			panic(err)
+		}
+	}
 
-	tpl, err := template.New("").Parse(cfg.UserMessageTemplate)
+	profiles, routes, err := compileAgentProfiles(cfg)
 	if err != nil {
 		panic(err)
 	}
@@ -53,9 +89,11 @@ func MustNew(cfg *Config) *WxAutoRunner {
 		panic(err)
 	}
 	return &WxAutoRunner{
-		userMessageTemplate: tpl,
-		msgFilter:           msgFilter,
-		cfg:                 cfg,
+		profiles:  profiles,
+		routes:    routes,
+		msgFilter: msgFilter,
+		chatLog:   newChatLog(),
+		cfg:       cfg,
 	}
 }
 func (b *WxAutoRunner) Name() string {
@@ -68,48 +106,171 @@ func (b *WxAutoRunner) Run(ctx context.Context) error {
 	logger.Info().Msg("BotWorkerRunner is starting")
 	defer logger.Info().Msg("BotWorkerRunner has stopped")
 
+	if b.cfg.Provision != nil {
+		if err := natsprovision.Provision(ctx, b.cfg.Provision); err != nil {
+			logger.Error().Err(err).Msg("Failed to provision NATS streams and consumers")
+			return err
+		}
+	}
+
 	producer, err := natsproducer.New(&b.cfg.Producer)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to create NATS producer")
 		return err
 	}
+	defer producer.Close()
 
-	reactAgent, err := reactagent.New(ctx, &b.cfg.ReactAgent)
+	reactAgents, err := b.newReactAgents(ctx,
+		reactagent.WithMessageSender(&messageSender{producer: producer}),
+		reactagent.WithChatLog(b.chatLog),
+	)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to create ReactAgent")
 		return err
 	}
+	defer closeReactAgents(ctx, reactAgents)
 
-	consumer := natsconsumer.New(&b.cfg.Consumer)
-	consumer.Run(ctx, func(ctx context.Context, msg *nats.Msg) natsconsumer.HandleResult {
+	// 同一会话的消息依次处理，保证回复顺序与会话历史一致，不同会话并行处理
+	consumer := natsconsumer.New(&b.cfg.Consumer, natsconsumer.WithOrderingKey(chatNameOrderingKey))
+	b.running.Store(&runState{producer: producer, consumer: consumer, reactAgents: reactAgents})
+	defer b.running.Store(nil)
+	return consumer.Run(ctx, func(ctx context.Context, msg *nats.Msg) natsconsumer.HandleResult {
 		return b.handleMessage(&Context{
-			Context:    ctx,
-			reactAgent: reactAgent,
-			producer:   producer,
+			Context:     ctx,
+			reactAgents: reactAgents,
+			producer:    producer,
 		}, msg)
 	})
-	return nil
+}
+
+// Ready 消费者已绑定并开始接收消息，此时生产者与 React Agent 均已创建
+func (b *WxAutoRunner) Ready() bool {
+	state := b.running.Load()
+	return state != nil && state.consumer.Ready()
+}
+
+// Health 检查 NATS 连接、模型提供方与 MCP 服务器，模型全部熔断或 MCP 服务器断开时视为降级
+func (b *WxAutoRunner) Health() error {
+	state := b.running.Load()
+	if state == nil {
+		return errors.New("runner is not running")
+	}
+	errs := []error{state.consumer.Health()}
+	if err := state.producer.Health(); err != nil {
+		errs = append(errs, fmt.Errorf("producer: %w", err))
+	}
+	for _, name := range slices.Sorted(maps.Keys(state.reactAgents)) {
+		errs = append(errs, agentHealth(name, state.reactAgents[name]))
+	}
+	return errors.Join(errs...)
+}
+
+// agentHealth 检查 React Agent 是否至少有一个可用的模型提供方，以及 MCP 服务器是否均已连接
+func agentHealth(name string, agent *reactagent.ReactAgent) error {
+	var errs []error
+	providers := agent.ModelHealth()
+	available := false
+	for _, p := range providers {
+		available = available || p.Available
+	}
+	if len(providers) > 0 && !available {
+		errs = append(errs, fmt.Errorf("agent %s: all model providers are unavailable", name))
+	}
+	for _, s := range agent.MCPStatus() {
+		if !s.Connected {
+			errs = append(errs, fmt.Errorf("agent %s: mcp server %s is disconnected: %s", name, s.Name, s.LastError))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status 微信机器人的运行状态
+type Status struct {
+	Running  bool                 `json:"running"`            // 是否正在运行
+	Producer *natsproducer.Status `json:"producer,omitempty"` // 回复消息生产者
+	Consumer *natsconsumer.Status `json:"consumer,omitempty"` // 收到消息的消费者，包含消费滞后
+	Agents   []AgentStatus        `json:"agents,omitempty"`   // 各 Agent 配置的模型与 MCP 服务器
+}
+
+// AgentStatus 一个 Agent 配置对应的 React Agent 的状态
+type AgentStatus struct {
+	Profile    string                       `json:"profile"`     // Agent 配置名称
+	Models     []reactagent.ProviderHealth  `json:"models"`      // 模型提供方的健康状态
+	MCPServers []reactagent.MCPServerStatus `json:"mcp_servers"` // MCP 服务器的连接状态与已加载的工具
+}
+
+func (b *WxAutoRunner) Status(ctx context.Context) any {
+	state := b.running.Load()
+	if state == nil {
+		return Status{}
+	}
+	producer := state.producer.Status()
+	consumer := state.consumer.Status(ctx)
+	st := Status{Running: true, Producer: &producer, Consumer: &consumer}
+	for _, name := range slices.Sorted(maps.Keys(state.reactAgents)) {
+		agent := state.reactAgents[name]
+		st.Agents = append(st.Agents, AgentStatus{
+			Profile:    name,
+			Models:     agent.ModelHealth(),
+			MCPServers: agent.MCPStatus(),
+		})
+	}
+	return st
+}
+
+// chatNameOrderingKey 以会话名称作为消息的顺序键，无法解析的消息不保证顺序
+func chatNameOrderingKey(msg *nats.Msg) string {
+	var m ReceivedMessage
+	if err := json.Unmarshal(msg.Data, &m); err != nil {
+		return ""
+	}
+	return m.Info.ChatName
+}
+
+// messageSender 通过 NATS 生产者向指定会话发送消息，供 send_message 内置工具使用
+type messageSender struct {
+	producer *natsproducer.Producer
+}
+
+func (s *messageSender) SendMessage(ctx context.Context, chatName string, content string) error {
+	data, err := json.Marshal(SendMessage{
+		SendToChat: chatName,
+		Content:    content,
+		Exact:      true,
+	})
+	if err != nil {
+		return err
+	}
+	return s.producer.Publish(ctx, data)
 }
 
 type Context struct {
 	context.Context
-	reactAgent *reactagent.ReactAgent // React Agent 实例
-	producer   *natsproducer.Producer // NATS 生产者实例
+	reactAgents map[string]*reactagent.ReactAgent // 各 Agent 配置对应的 React Agent 实例
+	producer    *natsproducer.Producer            // NATS 生产者实例
 }
 
 func (b *WxAutoRunner) handleMessage(ctx *Context, natsMsg *nats.Msg) natsconsumer.HandleResult {
 	logger := zerolog.Ctx(ctx)
 	// 处理消息的逻辑
 	logger.Info().Str("subject", natsMsg.Subject).Msg("Received message")
+	if env, ok := envelope.FromContext(ctx); ok && env.ContentType != envelope.ContentTypeJSON {
+		logger.Error().Str("content_type", env.ContentType).Msg("Unsupported content type, skipping")
+		natsconsumer.SetFailureReason(ctx, "unsupported content type: "+env.ContentType)
+		return natsconsumer.HandleResultTerm
+	}
 	var msg ReceivedMessage
 	if err := json.Unmarshal(natsMsg.Data, &msg); err != nil {
 		logger.Error().Err(err).Msg("Failed to unmarshal message")
+		natsconsumer.SetFailureReason(ctx, "failed to unmarshal message: "+err.Error())
 		return natsconsumer.HandleResultTerm
 	}
 	logger.Info().Any("wxauto_message", msg).Msg("Processed wxauto message")
+	// 所有消息都计入会话记录，包括不需要回复的消息
+	b.chatLog.Add(&msg)
 
 	if msg.Attr != MessageAttrFriend {
 		logger.Warn().Str("attr", string(msg.Attr)).Msg("Unsupported message attribute, skipping")
+		natsconsumer.SetFailureReason(ctx, "unsupported message attribute: "+string(msg.Attr))
 		return natsconsumer.HandleResultTerm
 	}
 	// if msg.Type != MessageTypeText {
@@ -121,6 +282,7 @@ func (b *WxAutoRunner) handleMessage(ctx *Context, natsMsg *nats.Msg) natsconsum
 	ret, err := expr.Run(b.msgFilter, msg)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to run message filter")
+		natsconsumer.SetFailureReason(ctx, "failed to run message filter: "+err.Error())
 		return natsconsumer.HandleResultTerm
 	}
 	if ret == nil || !ret.(bool) {
@@ -128,35 +290,82 @@ func (b *WxAutoRunner) handleMessage(ctx *Context, natsMsg *nats.Msg) natsconsum
 		return natsconsumer.HandleResultAck
 	}
 
+	// 选择 Agent 配置
+	profile := b.route(ctx, &msg)
+	logger.Info().Str("agent_profile", profile.name).Msg("Routed message to agent profile")
+
 	// 执行用户消息模板
 	buf := bytes.Buffer{}
-	if err := b.userMessageTemplate.Execute(&buf, msg); err != nil {
+	if err := profile.userMessageTemplate.Execute(&buf, msg); err != nil {
 		logger.Error().Err(err).Msg("Failed to execute template")
+		natsconsumer.SetFailureReason(ctx, "failed to execute template: "+err.Error())
 		return natsconsumer.HandleResultTerm
 	}
-	answer, err := ctx.reactAgent.Question(ctx, buf.String())
+	sessionKey := profile.reactAgentCfg.History.SessionKey(msg.Info.ChatName, msg.Sender)
+	questionCtx := reactagent.WithCaller(ctx, &reactagent.Caller{
+		Sender:       msg.Sender,
+		SenderRemark: msg.SenderRemark,
+		ChatName:     msg.Info.ChatName,
+		MessageID:    msg.ID,
+	})
+	agent := ctx.reactAgents[profile.name]
+	reply := newReplier(ctx, ctx.producer, &msg, &b.cfg.Streaming)
+	var answer string
+	if b.cfg.Streaming.Enabled {
+		// 流式回复时回答凑满一段即发送，answer 只保留最后未发送的内容
+		_, err = agent.QuestionStream(questionCtx, sessionKey, buf.String(), reply.Write)
+		answer = reply.chunker.Flush()
+	} else {
+		answer, err = agent.Question(questionCtx, sessionKey, buf.String())
+	}
+	if reply.err != nil {
+		// 流式回复时发送失败会中断推理
+		return publishFailed(ctx, reply)
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "exceeded max steps") {
-			logger.Warn().Err(err).Msg("ReactAgent exceeded max steps, skipping message")
-			answer = "抱歉，我无法处理这个请求，当前问题过于复杂"
+		var limitErr *reactagent.LimitError
+		if errors.As(err, &limitErr) {
+			// 触发运行限制时使用根据已有推理得到的回答，流式回复时部分回答已经陆续发出
+			logger.Warn().Err(err).Str("limit", string(limitErr.Kind)).Msg("ReactAgent exceeded limit")
+			if !b.cfg.Streaming.Enabled {
+				answer = limitErr.Partial
+			}
+			if answer == "" && reply.sent == 0 {
+				answer = "抱歉，我无法处理这个请求，当前问题过于复杂"
+			}
+		} else if reactagent.IsTransientError(err) && reply.sent == 0 {
+			// 模型暂时不可用，按重试计划稍后重新处理
+			logger.Error().Err(err).Msg("ReactAgent is temporarily unavailable, message will be retried")
+			natsconsumer.SetFailureReason(ctx, "model unavailable: "+err.Error())
+			return natsconsumer.HandleResultRetry
+		} else if reply.sent > 0 {
+			// 部分回答已经发出，不再追加错误提示，只发送剩余的内容
+			logger.Error().Err(err).Int("sent", reply.sent).Msg("ReactAgent failed after part of the answer was sent")
 		} else {
 			logger.Error().Err(err).Msg("Failed to get answer from ReactAgent")
 			answer = "破防，遇到了一些无法处理的错误: " + err.Error()
 		}
 	}
-	// 将回答转换为 JSON
-	answerData, _ := json.Marshal(SendMessage{
-		Content:      answer,
-		ReplyToMsgID: msg.ID,               // 回复原消息
-		SendToChat:   msg.Sender,           // 回复给发送者
-		At:           []string{msg.Sender}, // 在群聊中 @ 发送者
-		Exact:        true,                 // 精确匹配发送者名称
-	})
+	if answer == "" {
+		return natsconsumer.HandleResultAck
+	}
 
 	// 发送回答
-	if err := ctx.producer.Publish(answerData); err != nil {
-		logger.Error().Err(err).Msg("Failed to publish message")
-		return natsconsumer.HandleResultNak
+	if err := reply.Send(answer); err != nil {
+		return publishFailed(ctx, reply)
 	}
 	return natsconsumer.HandleResultAck
 }
+
+// publishFailed 回复发送失败：尚未发出任何回复时按重试计划重新处理；已发出部分回复时，
+// 重新处理会再次运行 Agent（包括有副作用的工具）并重复回答，因此只记录日志并确认
+func publishFailed(ctx context.Context, reply *replier) natsconsumer.HandleResult {
+	logger := zerolog.Ctx(ctx)
+	if reply.sent > 0 {
+		logger.Error().Err(reply.err).Int("sent", reply.sent).Msg("Failed to publish the rest of the reply, dropping it")
+		return natsconsumer.HandleResultAck
+	}
+	logger.Error().Err(reply.err).Msg("Failed to publish message")
+	natsconsumer.SetFailureReason(ctx, "failed to publish reply: "+reply.err.Error())
+	return natsconsumer.HandleResultRetry
+}
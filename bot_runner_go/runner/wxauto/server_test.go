@@ -0,0 +1,406 @@
+package wxauto
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meguminnnnnnnnn/go-openai"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/chathistory"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/envelope"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsconsumer"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsproducer"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsprovision"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/reactagent"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/testharness"
+)
+
+// echoReply 回答最后一条用户消息，便于检查模板是否生效
+func echoReply(messages []openai.ChatCompletionMessage) string {
+	return "收到：" + messages[len(messages)-1].Content
+}
+
+func newTestConfig(srv *testharness.NatsServer, chat *testharness.FakeChatModel) *Config {
+	return &Config{
+		Producer: natsproducer.Config{
+			NatsURL: srv.URL(),
+			Subject: "BOTS.send_msgs",
+			Mode:    natsproducer.PublishModeJetStream,
+		},
+		Consumer: natsconsumer.Config{
+			NatsURL:      srv.URL(),
+			Subject:      "BOTS.received_msgs",
+			ConsumerName: "WX_MSGS_CONSUMER",
+		},
+		ReactAgent: reactagent.Config{
+			SystemPrompt: "你是糖糖",
+			Models: []reactagent.ModelConfig{
+				{Name: "fake", BaseURL: chat.BaseURL(), APIKey: "test", Model: "fake-model"},
+			},
+		},
+		UserMessageTemplate:    "{{.Sender}}: {{.Content}}",
+		UserMessageReplyFilter: `Content startsWith "@糖糖"`,
+		Provision: &natsprovision.Config{
+			Streams: []natsprovision.StreamConfig{{
+				Name:     "BOTS_STREAM",
+				Subjects: []string{"BOTS.*"},
+				Consumers: []natsprovision.ConsumerConfig{
+					{Name: "WX_MSGS_CONSUMER", FilterSubject: "BOTS.received_msgs"},
+				},
+			}},
+		},
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	logger := zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.DebugLevel)
+	return logger.WithContext(context.Background())
+}
+
+// newTestHandler 创建 Runner 与 handleMessage 所需的上下文，流与消费者已创建
+func newTestHandler(t *testing.T, cfg *Config) (*WxAutoRunner, *Context) {
+	b := MustNew(cfg)
+	ctx := testContext(t)
+	require.NoError(t, natsprovision.Provision(ctx, cfg.Provision))
+
+	producer, err := natsproducer.New(&cfg.Producer)
+	require.NoError(t, err)
+	t.Cleanup(producer.Close)
+	agents, err := b.newReactAgents(ctx,
+		reactagent.WithMessageSender(&messageSender{producer: producer}),
+		reactagent.WithChatLog(b.chatLog),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { closeReactAgents(ctx, agents) })
+
+	ctx = envelope.WithContext(ctx, envelope.New("wx"))
+	return b, &Context{Context: ctx, reactAgents: agents, producer: producer}
+}
+
+func receivedMsg(t *testing.T, msg ReceivedMessage) *nats.Msg {
+	data, err := json.Marshal(msg)
+	require.NoError(t, err)
+	return &nats.Msg{Subject: "BOTS.received_msgs", Data: data}
+}
+
+// sentMessages 返回已发送到 BOTS.send_msgs 的消息
+func sentMessages(t *testing.T, srv *testharness.NatsServer) []SendMessage {
+	ctx := context.Background()
+	stream, err := srv.JetStream().Stream(ctx, "BOTS_STREAM")
+	require.NoError(t, err)
+	info, err := stream.Info(ctx)
+	require.NoError(t, err)
+
+	var sent []SendMessage
+	if info.State.Msgs == 0 {
+		return sent
+	}
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq; seq++ {
+		raw, err := stream.GetMsg(ctx, seq)
+		require.NoError(t, err)
+		if raw.Subject != "BOTS.send_msgs" {
+			continue
+		}
+		var msg SendMessage
+		require.NoError(t, json.Unmarshal(raw.Data, &msg))
+		sent = append(sent, msg)
+	}
+	return sent
+}
+
+func TestHandleMessage(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	chat := testharness.StartFakeChatModel(t, echoReply)
+	b, ctx := newTestHandler(t, newTestConfig(srv, chat))
+
+	result := b.handleMessage(ctx, receivedMsg(t, ReceivedMessage{
+		ID:      "msg-1",
+		Type:    MessageTypeText,
+		Attr:    MessageAttrFriend,
+		Content: "@糖糖 你好",
+		Sender:  "alice",
+		Info:    ChatInfo{ChatType: string(ChatTypeGroup), ChatName: "测试群"},
+	}))
+	require.Equal(t, natsconsumer.HandleResultAck, result)
+
+	// 用户消息经过模板渲染后提交给模型，回答回复给发送者
+	requests := chat.Requests()
+	require.Len(t, requests, 1)
+	require.Equal(t, "alice: @糖糖 你好", requests[0].Messages[len(requests[0].Messages)-1].Content)
+	require.Equal(t, []SendMessage{{
+		ReplyToMsgID: "msg-1",
+		SendToChat:   "alice",
+		Content:      "收到：alice: @糖糖 你好",
+		At:           []string{"alice"},
+		Exact:        true,
+	}}, sentMessages(t, srv))
+}
+
+func TestHandleMessageSkipped(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	chat := testharness.StartFakeChatModel(t, echoReply)
+	b, ctx := newTestHandler(t, newTestConfig(srv, chat))
+
+	// 不满足过滤规则的消息直接确认，但仍然计入会话记录
+	result := b.handleMessage(ctx, receivedMsg(t, ReceivedMessage{
+		ID:      "msg-1",
+		Attr:    MessageAttrFriend,
+		Content: "大家好",
+		Sender:  "bob",
+		Info:    ChatInfo{ChatType: string(ChatTypeGroup), ChatName: "测试群"},
+	}))
+	require.Equal(t, natsconsumer.HandleResultAck, result)
+	recent, err := b.chatLog.RecentMessages(ctx, "测试群", 10)
+	require.NoError(t, err)
+	require.Len(t, recent, 1)
+	require.Equal(t, "大家好", recent[0].Content)
+
+	// 非好友消息与无法解析的消息被丢弃
+	result = b.handleMessage(ctx, receivedMsg(t, ReceivedMessage{Attr: MessageAttrSystem, Content: "@糖糖 系统消息"}))
+	require.Equal(t, natsconsumer.HandleResultTerm, result)
+	result = b.handleMessage(ctx, &nats.Msg{Subject: "BOTS.received_msgs", Data: []byte("not json")})
+	require.Equal(t, natsconsumer.HandleResultTerm, result)
+
+	require.Empty(t, chat.Requests())
+	require.Empty(t, sentMessages(t, srv))
+}
+
+func TestHandleMessageStreaming(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	chat := testharness.StartFakeChatModel(t, func(messages []openai.ChatCompletionMessage) string {
+		return "第一句话。第二句话！最后一句"
+	})
+	cfg := newTestConfig(srv, chat)
+	cfg.Streaming = StreamingConfig{Enabled: true, MinChunkSize: 1, PacingDelay: time.Millisecond}
+	b, ctx := newTestHandler(t, cfg)
+
+	result := b.handleMessage(ctx, receivedMsg(t, ReceivedMessage{ID: "msg-1", Attr: MessageAttrFriend, Content: "@糖糖 讲讲", Sender: "alice"}))
+	require.Equal(t, natsconsumer.HandleResultAck, result)
+
+	var contents []string
+	for _, msg := range sentMessages(t, srv) {
+		contents = append(contents, msg.Content)
+	}
+	require.Equal(t, []string{"第一句话。", "第二句话！", "最后一句"}, contents)
+}
+
+func TestHandleMessageStreamingToolCall(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	chat := testharness.StartFakeToolCallingModel(t, func(messages []openai.ChatCompletionMessage) testharness.Reply {
+		last := messages[len(messages)-1]
+		if last.Role == openai.ChatMessageRoleTool {
+			return testharness.Reply{Content: "算出来了。结果是 " + last.Content}
+		}
+		// 先输出一段思考再调用工具
+		return testharness.Reply{
+			Content: "让我算一下。",
+			ToolCalls: []openai.ToolCall{{
+				ID:       "call-1",
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: "calculator", Arguments: `{"expression": "(1 + 2) * 3"}`},
+			}},
+		}
+	})
+	cfg := newTestConfig(srv, chat)
+	cfg.Streaming = StreamingConfig{Enabled: true, MinChunkSize: 1, PacingDelay: time.Millisecond}
+	b, ctx := newTestHandler(t, cfg)
+
+	// 工具调用之前的文本不会作为回答发出，工具结果交给模型生成最终回答
+	result := b.handleMessage(ctx, receivedMsg(t, ReceivedMessage{ID: "msg-1", Attr: MessageAttrFriend, Content: "@糖糖 算算", Sender: "alice"}))
+	require.Equal(t, natsconsumer.HandleResultAck, result)
+	require.Len(t, chat.Requests(), 2)
+
+	var contents []string
+	for _, msg := range sentMessages(t, srv) {
+		contents = append(contents, msg.Content)
+	}
+	require.Equal(t, []string{"算出来了。", `结果是 {"result":9}`}, contents)
+}
+
+func TestHandleMessageStreamingBeforeModelFinished(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	hold := make(chan struct{})
+	release := sync.OnceFunc(func() { close(hold) })
+	chat := testharness.StartFakeToolCallingModel(t, func(messages []openai.ChatCompletionMessage) testharness.Reply {
+		return testharness.Reply{Content: "第一句话。" + strings.Repeat("后面还有很长的内容，", 5) + "结束。", Hold: hold}
+	})
+	// 断言失败时同样结束模型输出，避免关闭模型服务时等待未结束的请求
+	t.Cleanup(release)
+	cfg := newTestConfig(srv, chat)
+	cfg.Streaming = StreamingConfig{Enabled: true, MinChunkSize: 1, PacingDelay: time.Millisecond}
+	b, ctx := newTestHandler(t, cfg)
+
+	result := make(chan natsconsumer.HandleResult, 1)
+	go func() {
+		result <- b.handleMessage(ctx, receivedMsg(t, ReceivedMessage{ID: "msg-1", Attr: MessageAttrFriend, Content: "@糖糖 讲讲", Sender: "alice"}))
+	}()
+
+	// 已启用工具时，模型输出文本后不必等到输出结束才判断没有工具调用，第一句在模型输出结束之前发出
+	require.Eventually(t, func() bool {
+		return len(sentMessages(t, srv)) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "第一句话。", sentMessages(t, srv)[0].Content)
+	select {
+	case <-result:
+		t.Fatal("message handled before the model stream finished")
+	default:
+	}
+
+	release()
+	require.Equal(t, natsconsumer.HandleResultAck, <-result)
+	require.Len(t, chat.Requests(), 1)
+}
+
+func TestHandleMessageRedelivered(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	answers := []string{"第一句话。第二句话！", "另一个开头。换个说法！", "只有一句。", "另一个开头。还有一句！"}
+	var calls atomic.Int32
+	chat := testharness.StartFakeChatModel(t, func(messages []openai.ChatCompletionMessage) string {
+		return answers[calls.Add(1)-1]
+	})
+	cfg := newTestConfig(srv, chat)
+	cfg.Streaming = StreamingConfig{Enabled: true, MinChunkSize: 1, PacingDelay: time.Millisecond}
+	b, ctx := newTestHandler(t, cfg)
+	contents := func() []string {
+		var ret []string
+		for _, msg := range sentMessages(t, srv) {
+			ret = append(ret, msg.Content)
+		}
+		return ret
+	}
+
+	// 重新投递的消息即使得到不同的回答，回复 ID 由原消息 ID 与分段序号决定，已发送过的分段被 JetStream 丢弃
+	msg := ReceivedMessage{ID: "msg-1", Attr: MessageAttrFriend, Content: "@糖糖 讲讲", Sender: "alice"}
+	require.Equal(t, natsconsumer.HandleResultAck, b.handleMessage(ctx, receivedMsg(t, msg)))
+	require.Equal(t, natsconsumer.HandleResultAck, b.handleMessage(ctx, receivedMsg(t, msg)))
+	require.Equal(t, []string{"第一句话。", "第二句话！"}, contents())
+
+	// 重新生成的回答分段更多时，超出部分仍会发送，用户收到的是两次回答拼接的内容
+	msg.ID = "msg-2"
+	require.Equal(t, natsconsumer.HandleResultAck, b.handleMessage(ctx, receivedMsg(t, msg)))
+	require.Equal(t, natsconsumer.HandleResultAck, b.handleMessage(ctx, receivedMsg(t, msg)))
+	require.Equal(t, []string{"第一句话。", "第二句话！", "只有一句。", "还有一句！"}, contents())
+}
+
+func TestHandleMessageRedeliveredHistory(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	chat := testharness.StartFakeChatModel(t, echoReply)
+	cfg := newTestConfig(srv, chat)
+	cfg.ReactAgent.History = &chathistory.Config{}
+	b, ctx := newTestHandler(t, cfg)
+
+	// 重新投递的消息再次回答，但会话历史只记录一次
+	msg := ReceivedMessage{ID: "msg-1", Attr: MessageAttrFriend, Content: "@糖糖 你好", Sender: "alice"}
+	require.Equal(t, natsconsumer.HandleResultAck, b.handleMessage(ctx, receivedMsg(t, msg)))
+	require.Equal(t, natsconsumer.HandleResultAck, b.handleMessage(ctx, receivedMsg(t, msg)))
+	msg.ID, msg.Content = "msg-2", "@糖糖 在吗"
+	require.Equal(t, natsconsumer.HandleResultAck, b.handleMessage(ctx, receivedMsg(t, msg)))
+
+	requests := chat.Requests()
+	require.Len(t, requests, 3)
+	var contents []string
+	for _, m := range requests[2].Messages[1:] {
+		contents = append(contents, m.Content)
+	}
+	require.Equal(t, []string{"alice: @糖糖 你好", "收到：alice: @糖糖 你好", "alice: @糖糖 在吗"}, contents)
+}
+
+func TestHandleMessageStreamingPublishFailed(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	chat := testharness.StartFakeChatModel(t, func(messages []openai.ChatCompletionMessage) string {
+		return "第一句话。第二句话！"
+	})
+	cfg := newTestConfig(srv, chat)
+	cfg.Streaming = StreamingConfig{Enabled: true, MinChunkSize: 1, PacingDelay: time.Millisecond}
+	// 没有流保存回复主题，JetStream 发布失败
+	cfg.Producer.Subject = "NO_STREAM.send_msgs"
+	cfg.Producer.AckTimeout = 100 * time.Millisecond
+	cfg.Producer.RetryWait = time.Millisecond
+	b, ctx := newTestHandler(t, cfg)
+
+	// 发送失败不是 Agent 出错，不发送错误提示，按重试计划稍后重新处理
+	result := b.handleMessage(ctx, receivedMsg(t, ReceivedMessage{ID: "msg-1", Attr: MessageAttrFriend, Content: "@糖糖 讲讲", Sender: "alice"}))
+	require.Equal(t, natsconsumer.HandleResultRetry, result)
+	require.Len(t, chat.Requests(), 1)
+}
+
+func TestHandleMessageStreamingPartiallyPublished(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	chat := testharness.StartFakeChatModel(t, func(messages []openai.ChatCompletionMessage) string {
+		return "第一句话。第二句话！"
+	})
+	cfg := newTestConfig(srv, chat)
+	cfg.Streaming = StreamingConfig{Enabled: true, MinChunkSize: 1, PacingDelay: time.Millisecond}
+	// 回复主题所在的流只能保存一条消息，第二条回复发送失败
+	cfg.Producer.Subject = "REPLIES.send_msgs"
+	srv.CreateStream(jetstream.StreamConfig{
+		Name:     "REPLIES",
+		Subjects: []string{"REPLIES.*"},
+		MaxMsgs:  1,
+		Discard:  jetstream.DiscardNew,
+	})
+	b, ctx := newTestHandler(t, cfg)
+
+	// 已发出部分回复后发送失败，重新处理会重复回答，只确认消息
+	result := b.handleMessage(ctx, receivedMsg(t, ReceivedMessage{ID: "msg-1", Attr: MessageAttrFriend, Content: "@糖糖 讲讲", Sender: "alice"}))
+	require.Equal(t, natsconsumer.HandleResultAck, result)
+	require.Len(t, chat.Requests(), 1)
+}
+
+func TestRunEndToEnd(t *testing.T) {
+	srv := testharness.StartNatsServer(t)
+	chat := testharness.StartFakeChatModel(t, echoReply)
+	b := MustNew(newTestConfig(srv, chat))
+
+	ctx, cancel := context.WithCancel(testContext(t))
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	// 预配流与消费者并绑定后视为就绪
+	require.Eventually(t, b.Ready, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, b.Health())
+
+	// 消息经过消费者、模型与生产者，回复发送到 BOTS.send_msgs
+	js := srv.JetStream()
+	data, err := json.Marshal(ReceivedMessage{ID: "msg-1", Attr: MessageAttrFriend, Content: "@糖糖 在吗", Sender: "alice"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := js.Publish(context.Background(), "BOTS.received_msgs", data)
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	sub, err := js.OrderedConsumer(context.Background(), "BOTS_STREAM", jetstream.OrderedConsumerConfig{FilterSubjects: []string{"BOTS.send_msgs"}})
+	require.NoError(t, err)
+	raw, err := sub.Next(jetstream.FetchMaxWait(10 * time.Second))
+	require.NoError(t, err)
+	var reply SendMessage
+	require.NoError(t, json.Unmarshal(raw.Data(), &reply))
+	require.Equal(t, "msg-1", reply.ReplyToMsgID)
+	require.True(t, strings.HasPrefix(reply.Content, "收到：alice: @糖糖 在吗"))
+
+	// 状态页包含连接状态、消费滞后与 Agent 的模型状态
+	st := b.Status(context.Background()).(Status)
+	require.True(t, st.Running)
+	require.Equal(t, "CONNECTED", st.Producer.Connection)
+	require.Equal(t, "BOTS_STREAM", st.Consumer.Stream)
+	require.Zero(t, st.Consumer.NumPending)
+	require.Len(t, st.Agents, 1)
+	require.True(t, st.Agents[0].Models[0].Available)
+
+	// 回复沿用所收到消息的追踪 ID
+	env, err := envelope.Read(raw.Headers())
+	require.NoError(t, err)
+	require.NotEmpty(t, env.InReplyTo)
+}
@@ -0,0 +1,185 @@
+package wxauto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog"
+	"github.com/zhangzqs/sugar-wechat-bot/bot_runner_go/pkg/natsproducer"
+)
+
+type StreamingConfig struct {
+	Enabled      bool          `yaml:"enabled"`        // 是否流式回复，开启后回答按段落或句子切分为多条消息陆续发送
+	MinChunkSize int           `yaml:"min_chunk_size"` // 每条消息至少包含的字符数，默认 50
+	PacingDelay  time.Duration `yaml:"pacing_delay"`   // 相邻两条消息的最小发送间隔，默认 1s
+}
+
+func (c *StreamingConfig) Validate() error {
+	if c.MinChunkSize == 0 {
+		c.MinChunkSize = 50
+	}
+	if c.PacingDelay == 0 {
+		c.PacingDelay = 1 * time.Second
+	}
+
+	if c.MinChunkSize < 0 {
+		return errors.New("min_chunk_size must not be negative")
+	}
+	if c.PacingDelay < 0 {
+		return errors.New("pacing_delay must not be negative")
+	}
+	return nil
+}
+
+// 句子结束符，英文句点只有后面跟着空白时才视为句子结束，避免切开小数与网址
+const sentenceEnds = "。！？；!?;\n"
+
+// 紧跟在句子结束符之后、应当归入上一句的字符
+const sentenceClosers = "”’\"'）)」』】"
+
+// chunker 把流式回答切分为多段，优先在段落边界切分，其次在句子边界切分，每段至少 minSize 个字符
+type chunker struct {
+	minSize int
+	buf     []rune
+}
+
+// Write 写入增量内容，返回已经可以发送的完整分段
+func (c *chunker) Write(delta string) []string {
+	c.buf = append(c.buf, []rune(delta)...)
+	var chunks []string
+	for {
+		cut := c.cutIndex()
+		if cut <= 0 {
+			return chunks
+		}
+		if chunk := strings.TrimSpace(string(c.buf[:cut])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		c.buf = c.buf[cut:]
+	}
+}
+
+// Flush 返回剩余的全部内容
+func (c *chunker) Flush() string {
+	chunk := strings.TrimSpace(string(c.buf))
+	c.buf = nil
+	return chunk
+}
+
+// cutIndex 返回第一个满足最小长度的切分位置，段落边界优先，找不到时返回 0
+func (c *chunker) cutIndex() int {
+	sentenceCut := 0
+	for i := max(c.minSize-1, 0); i < len(c.buf); i++ {
+		r := c.buf[i]
+		// 段落边界：连续的两个换行
+		if r == '\n' && i+1 < len(c.buf) && c.buf[i+1] == '\n' {
+			return c.skipClosers(i + 2)
+		}
+		if sentenceCut > 0 {
+			continue
+		}
+		if strings.ContainsRune(sentenceEnds, r) {
+			// 单个换行可能是段落边界的前半部分，等待下一个字符
+			if r == '\n' && i+1 == len(c.buf) {
+				continue
+			}
+			sentenceCut = i + 1
+		} else if r == '.' && i+1 < len(c.buf) && unicode.IsSpace(c.buf[i+1]) {
+			sentenceCut = i + 1
+		}
+	}
+	if sentenceCut > 0 {
+		return c.skipClosers(sentenceCut)
+	}
+	return 0
+}
+
+func (c *chunker) skipClosers(i int) int {
+	for i < len(c.buf) && (strings.ContainsRune(sentenceClosers, c.buf[i]) || unicode.IsSpace(c.buf[i])) {
+		i++
+	}
+	return i
+}
+
+// replier 把回答发送给消息的发送者，流式回复时按段落或句子切分为多条消息，并控制发送间隔
+type replier struct {
+	ctx      context.Context
+	producer *natsproducer.Producer
+	msg      *ReceivedMessage
+	cfg      *StreamingConfig
+	chunker  *chunker
+	lastSent time.Time
+	sent     int   // 已发送的消息条数
+	err      error // 最近一次发送失败的原因，流式回复时用于区分发送失败与 Agent 出错
+}
+
+func newReplier(ctx context.Context, producer *natsproducer.Producer, msg *ReceivedMessage, cfg *StreamingConfig) *replier {
+	return &replier{
+		ctx:      ctx,
+		producer: producer,
+		msg:      msg,
+		cfg:      cfg,
+		chunker:  &chunker{minSize: cfg.MinChunkSize},
+	}
+}
+
+// Write 写入回答的增量内容，凑满一段后立即发送
+func (r *replier) Write(delta string) error {
+	for _, chunk := range r.chunker.Write(delta) {
+		if err := r.Send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send 立即发送一条回复消息，距上一条消息不足发送间隔时等待
+func (r *replier) Send(content string) error {
+	if err := r.send(content); err != nil {
+		r.err = err
+		return err
+	}
+	return nil
+}
+
+func (r *replier) send(content string) error {
+	if r.sent > 0 {
+		if wait := r.cfg.PacingDelay - time.Since(r.lastSent); wait > 0 {
+			select {
+			case <-r.ctx.Done():
+				return r.ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	data, err := json.Marshal(SendMessage{
+		Content:      content,
+		ReplyToMsgID: r.msg.ID,               // 回复原消息
+		SendToChat:   r.msg.Sender,           // 回复给发送者
+		At:           []string{r.msg.Sender}, // 在群聊中 @ 发送者
+		Exact:        true,                   // 精确匹配发送者名称
+	})
+	if err != nil {
+		return err
+	}
+	var opts []natsproducer.PublishOption
+	if r.msg.ID != "" {
+		// 同一条消息的第几条回复使用固定的 ID，重新处理时 JetStream 会丢弃已发送过的回复。
+		// 重新处理会重新生成回答，新回答与之前的不同时，只有序号超出已发送条数的分段会发出，
+		// 用户会收到前一次回答的开头与新回答的后续分段；这只发生在发出部分回复后进程崩溃或确认丢失的情况下
+		opts = append(opts, natsproducer.WithMsgID(fmt.Sprintf("reply-%s-%d", r.msg.ID, r.sent)))
+	}
+	if err := r.producer.Publish(r.ctx, data, opts...); err != nil {
+		return err
+	}
+	r.lastSent = time.Now()
+	r.sent++
+	zerolog.Ctx(r.ctx).Debug().Int("chunk_index", r.sent).Int("chunk_size", len([]rune(content))).Msg("Sent reply message")
+	return nil
+}
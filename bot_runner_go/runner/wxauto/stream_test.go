@@ -0,0 +1,31 @@
+package wxauto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunker(t *testing.T) {
+	c := &chunker{minSize: 4}
+	var chunks []string
+	for _, delta := range []string{"你好", "。今天天气", "很好！适合", "出门。\n", "\n第二段", "内容 3.14 end"} {
+		chunks = append(chunks, c.Write(delta)...)
+	}
+	// 不足最小长度的句子与下一句合并，小数点不会被当作句子结束
+	require.Equal(t, []string{"你好。今天天气很好！", "适合出门。", "第二段内容 3.14 end"}, append(chunks, c.Flush()))
+}
+
+func TestChunkerParagraphFirst(t *testing.T) {
+	c := &chunker{minSize: 2}
+	chunks := c.Write("第一句。第二句。\n\n第二段。")
+	require.Equal(t, []string{"第一句。第二句。", "第二段。"}, chunks)
+	require.Empty(t, c.Flush())
+}
+
+func TestChunkerKeepsClosingQuote(t *testing.T) {
+	c := &chunker{minSize: 2}
+	chunks := c.Write("他说：“好的。”然后走了")
+	require.Equal(t, []string{"他说：“好的。”"}, chunks)
+	require.Equal(t, "然后走了", c.Flush())
+}